@@ -3,6 +3,12 @@ module imagesToGridPdf
 go 1.22.4
 
 require (
+	github.com/jdeng/goheif v0.1.2
 	github.com/jung-kurt/gofpdf/v2 v2.17.3
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/image v0.24.0
 )
+
+require golang.org/x/text v0.22.0 // indirect
@@ -0,0 +1,81 @@
+package gridpdf
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isZipFile reports whether the image source is a ZIP archive rather than a folder.
+func isZipFile(source string) bool {
+	return strings.EqualFold(filepath.Ext(source), ".zip")
+}
+
+// openArchive opens the ZIP archive the images are read from.
+func (g *Generator) openArchive(source string) error {
+	archive, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	g.archive = archive
+	return nil
+}
+
+// closeArchive closes the ZIP archive, if one is open.
+func (g *Generator) closeArchive() {
+	if g.archive != nil {
+		g.archive.Close()
+		g.archive = nil
+	}
+}
+
+// listArchiveFiles returns the names of the image entries in the ZIP archive, sorted by name. Entries in
+// folders of the archive are only included when Recursive is set.
+func (g *Generator) listArchiveFiles() []string {
+	var names []string
+	for _, file := range g.archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if !g.cfg.Recursive && strings.Contains(file.Name, "/") {
+			continue
+		}
+		if g.includeFile(path.Base(file.Name)) {
+			names = append(names, file.Name)
+		}
+	}
+	sort.Strings(names) // Same name order as a folder
+	return names
+}
+
+// openImage opens the image at imagePath, which names an entry of the archive when the
+// images are read from a ZIP archive.
+func (g *Generator) openImage(imagePath string) (io.ReadCloser, error) {
+	if g.archive != nil {
+		return g.archive.Open(imagePath)
+	}
+	return os.Open(imagePath)
+}
+
+// readImageFile returns the contents of the image at imagePath, see openImage.
+func (g *Generator) readImageFile(imagePath string) ([]byte, error) {
+	file, err := g.openImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// statImage returns the file info of the image at imagePath, see openImage.
+func (g *Generator) statImage(imagePath string) (fs.FileInfo, error) {
+	if g.archive != nil {
+		return fs.Stat(g.archive, imagePath)
+	}
+	return os.Stat(imagePath)
+}
@@ -0,0 +1,75 @@
+package gridpdf
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheKey identifies the resized version of a source file. It changes whenever the file
+// or one of the options affecting the resized image changes, so stale entries are never used.
+func (g *Generator) cacheKey(path string, modTime time.Time, size int64) string {
+	cfg := g.cfg
+	// Every option used by resizeImageReader has to be part of the key
+	flipH, flipV := g.imageFlips(path)
+	params := []any{
+		g.imageWidth, g.imageHeight, cfg.Fit, cfg.Align, cfg.Interp, cfg.Background, cfg.Quality, cfg.CellFormat, cfg.NoFlatten,
+		cfg.NormalizeOrientation, cfg.Grayscale, cfg.Sepia, cfg.Brightness, cfg.Contrast, cfg.CornerRadius,
+		cfg.Overlay, cfg.OverlaySize, cfg.OverlayAlpha, cfg.OverlayFill, cfg.OverlayBorder, cfg.OverlayBorderWidth, cfg.OverlayPos, cfg.LogoPath,
+		g.overlayLabel(path), cfg.FontPath, cfg.QR, cfg.QRSize, cfg.QRPos, g.qrData(path),
+		flipH, flipV,
+	}
+	if g.archive != nil {
+		path = g.cfg.ImageFolder + "!" + path // Entry names are only unique within their archive
+	}
+	key := fmt.Sprintf("%s|%d|%d|%v", path, modTime.UnixNano(), size, params)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+}
+
+// resizeImageCached returns the resized image from the cache directory when it holds an
+// up-to-date copy, and otherwise resizes the image and stores the result in the cache.
+// Without a cache directory it simply resizes the image.
+func (g *Generator) resizeImageCached(path string, modTime time.Time, size int64) ([]byte, error) {
+	if g.cfg.CacheDir == "" {
+		return g.resizeImage(path)
+	}
+
+	cachePath := filepath.Join(g.cfg.CacheDir, g.cacheKey(path, modTime, size)+g.cellExt())
+	if data, err := os.ReadFile(cachePath); err == nil {
+		logDebugf("Using cached copy of %s", path)
+		return data, nil
+	}
+
+	data, err := g.resizeImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A cache that cannot be written only costs time on the next run
+	if err := writeFileAtomic(cachePath, data); err != nil {
+		logWarnf("Failed to cache resized image %s: %v", path, err)
+	}
+	return data, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place, so
+// Generators sharing a cache directory never read a partially written copy.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package gridpdf
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jung-kurt/gofpdf/v2"
+)
+
+// Layout of the call sheet entries in mm
+const (
+	callSheetThumb  = 15 // side length of the thumbnails
+	callSheetRow    = 18 // height of an entry including the gap below it
+	callSheetColumn = 60 // minimum width of a column of entries
+	callSheetHeader = 12 // height of the heading on the first page
+)
+
+// writeCallSheet saves a PDF listing every image that was placed, sorted by name, with a
+// thumbnail and the name of each, so a caller can announce them while playing.
+func (g *Generator) writeCallSheet(path string, images []gridImage) error {
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].name < images[j].name
+	})
+
+	cfg := g.cfg
+	pdf := newPDF(cfg)
+	g.addFont(pdf)
+	pageWidth, pageHeight := pdf.GetPageSize()
+	tr := pdf.UnicodeTranslatorFromDescriptor("") // Core fonts use cp1252, not UTF-8
+
+	width := pageWidth - cfg.MarginLeft - cfg.MarginRight
+	cols := max(int(width/callSheetColumn), 1)
+	colWidth := width / float64(cols)
+	rows := max(int((pageHeight-cfg.MarginTop-cfg.MarginBottom-callSheetHeader)/callSheetRow), 1)
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetXY(cfg.MarginLeft, cfg.MarginTop)
+	pdf.CellFormat(width, callSheetHeader-4, tr(fmt.Sprintf("Call Sheet (%d images)", len(images))), "", 0, "LM", false, 0, "")
+	top := cfg.MarginTop + callSheetHeader
+
+	// Thumbnails keep the aspect ratio of the cells within a square box
+	thumbWidth, thumbHeight := float64(callSheetThumb), float64(callSheetThumb)
+	if cfg.CellAspect > 1 {
+		thumbHeight /= cfg.CellAspect
+	} else {
+		thumbWidth *= cfg.CellAspect
+	}
+
+	tr = g.setNameFont(pdf, 10)
+	perPage := rows * cols
+	for i, img := range images {
+		// Fill the entries column by column, so the names read down the page in order
+		slot := i % perPage
+		if i > 0 && slot == 0 {
+			pdf.AddPage()
+			top = cfg.MarginTop
+		}
+		if slot == 0 && cfg.Stream {
+			g.registerStreamed(pdf, images[i:min(i+perPage, len(images))])
+		}
+		x := cfg.MarginLeft + float64(slot/rows)*colWidth
+		y := top + float64(slot%rows)*callSheetRow
+
+		if imageName, ok := g.registerImage(pdf, img); ok {
+			pdf.ImageOptions(imageName, x+(callSheetThumb-thumbWidth)/2, y+(callSheetThumb-thumbHeight)/2, thumbWidth, thumbHeight, false, gofpdf.ImageOptions{ImageType: g.cellImageType(), ReadDpi: true}, 0, "")
+		}
+		textWidth := colWidth - callSheetThumb - 4
+		pdf.SetXY(x+callSheetThumb+2, y)
+		pdf.CellFormat(textWidth, callSheetThumb, fitText(pdf, tr, img.name, textWidth), "", 0, "LM", false, 0, "")
+	}
+
+	if err := pdf.OutputFileAndClose(path); err != nil {
+		return fmt.Errorf("failed to write call sheet: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package gridpdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// writeTestImages saves n gradient JPEGs of width x height pixels in a temporary folder and
+// returns its path.
+func writeTestImages(tb testing.TB, n, width, height int) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8(i * 255 / n), 255})
+			}
+		}
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("img_%02d.jpg", i)))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		err = jpeg.Encode(f, img, nil)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// pdfContent returns the streams of a PDF: the page contents in order, followed by the
+// images sorted. gofpdf writes images of the same size in random order, so the order of
+// the images and the object numbers referring to them change from run to run.
+func pdfContent(data []byte) [][]byte {
+	var pages, images [][]byte
+	for _, obj := range bytes.Split(data, []byte("endobj")) {
+		m := pdfStream.FindSubmatch(obj)
+		if m == nil {
+			continue
+		}
+		if bytes.Contains(m[1], []byte("/Subtype /Image")) {
+			images = append(images, m[2])
+		} else {
+			pages = append(pages, m[2])
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return bytes.Compare(images[i], images[j]) < 0 })
+	return append(pages, images...)
+}
+
+var pdfStream = regexp.MustCompile(`(?s)obj\s*(<<.*>>)\s*stream\n(.*)\nendstream`)
+
+// TestGenerateReproducible checks that a seed reproduces the pages and images of the PDF, no
+// matter how many workers load and (with Stream) resize the images. The images all have the
+// same width, so gofpdf writes them in varying order and only the streams can be compared.
+func TestGenerateReproducible(t *testing.T) {
+	dir := writeTestImages(t, 8, 120, 90)
+	if err := os.WriteFile(filepath.Join(dir, "broken.jpg"), []byte("not a jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	SetProgress(false)
+	for _, stream := range []bool{false, true} {
+		var want [][]byte
+		for _, workers := range []int{1, 4, 4} {
+			cfg := DefaultConfig()
+			cfg.ImageFolder = dir
+			cfg.NumPages = 3
+			cfg.Rows, cfg.Cols = 3, 3
+			cfg.Stream = stream
+			cfg.Workers = workers
+			seed := int64(7)
+			cfg.Seed = &seed
+			cfg.RandomRotate = true
+			var g Generator
+			data, err := g.Generate(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := pdfContent(data)
+			if len(got) < cfg.NumPages+7 {
+				t.Fatalf("stream=%v: found %d streams, want at least the %d pages and 7 images", stream, len(got), cfg.NumPages)
+			}
+			if want == nil {
+				want = got
+				continue
+			}
+			if len(got) != len(want) {
+				t.Errorf("stream=%v, %d workers: got %d streams, want %d", stream, workers, len(got), len(want))
+				continue
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Errorf("stream=%v, %d workers: stream %d differs from the first run", stream, workers, i)
+				}
+			}
+		}
+	}
+}
+
+// TestGenerateByteIdentical checks that a fixed seed writes the same bytes when gofpdf has no
+// images of the same width to order, here a single image repeated on every cell.
+func TestGenerateByteIdentical(t *testing.T) {
+	dir := writeTestImages(t, 1, 120, 90)
+	SetProgress(false)
+	var want []byte
+	for run := 0; run < 2; run++ {
+		cfg := DefaultConfig()
+		cfg.ImageFolder = dir
+		cfg.NumPages = 3
+		cfg.Rows, cfg.Cols = 2, 2
+		seed := int64(5)
+		cfg.Seed = &seed
+		cfg.RandomRotate = true
+		var g Generator
+		data, err := g.Generate(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("/CreationDate (D:19700101000000)")) {
+			t.Error("the creation date is not fixed with a seed")
+		}
+		if run > 0 && !bytes.Equal(data, want) {
+			t.Error("the second run with the same seed wrote a different PDF")
+		}
+		want = data
+	}
+}
+
+// BenchmarkGenerate500Pages generates 500 pages of a 5x5 grid from 30 photos, with the
+// images preloaded and streamed.
+func BenchmarkGenerate500Pages(b *testing.B) {
+	dir := writeTestImages(b, 30, 1600, 1200)
+	SetProgress(false)
+	for _, stream := range []bool{false, true} {
+		b.Run(fmt.Sprintf("stream=%v", stream), func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.ImageFolder = dir
+			cfg.NumPages = 500
+			cfg.Stream = stream
+			seed := int64(1)
+			cfg.Seed = &seed
+			for i := 0; i < b.N; i++ {
+				var g Generator
+				if _, err := g.Generate(context.Background(), cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestGenerateFreeCenter checks that the free center cell does not swallow an image: 9 images
+// on two pages of 8 image cells are all placed.
+func TestGenerateFreeCenter(t *testing.T) {
+	dir := writeTestImages(t, 9, 40, 30)
+	SetProgress(false)
+	cfg := DefaultConfig()
+	cfg.ImageFolder = dir
+	cfg.NumPages = 2
+	cfg.Rows, cfg.Cols = 3, 3
+	cfg.FreeCenter = true
+	cfg.NoShuffle = true
+	cfg.ManifestPath = filepath.Join(t.TempDir(), "manifest.json")
+	var g Generator
+	if _, err := g.Generate(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cfg.ManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []manifestPage
+	if err := json.Unmarshal(data, &pages); err != nil {
+		t.Fatal(err)
+	}
+	placed := make(map[string]bool)
+	for _, page := range pages {
+		if len(page.Cells) != 8 {
+			t.Errorf("page %d has %d images, want 8", page.Page, len(page.Cells))
+		}
+		for _, cell := range page.Cells {
+			if cell.Row == 2 && cell.Col == 2 {
+				t.Errorf("page %d places %s in the free center", page.Page, cell.File)
+			}
+			placed[filepath.Base(cell.File)] = true
+		}
+	}
+	for i := 0; i < 9; i++ {
+		if name := fmt.Sprintf("img_%02d.jpg", i); !placed[name] {
+			t.Errorf("%s is never placed", name)
+		}
+	}
+}
+
+// TestGenerateDedup checks that a copy of an image file is placed only once, also with the
+// options that decorate each cell differently depending on the file name.
+func TestGenerateDedup(t *testing.T) {
+	dir := writeTestImages(t, 4, 40, 30)
+	data, err := os.ReadFile(filepath.Join(dir, "img_00.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "img_00_copy.jpg"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	SetProgress(false)
+
+	tests := []struct {
+		name     string
+		decorate func(*Config)
+	}{
+		{"plain", func(*Config) {}},
+		{"QR codes", func(cfg *Config) { cfg.QR = true }},
+		{"numbered overlay", func(cfg *Config) { cfg.OverlayText = "{n}" }},
+		{"random flips, seed 2", func(cfg *Config) {
+			cfg.RandomFlip, cfg.RandomFlipVertical = true, true
+			seed := int64(2)
+			cfg.Seed = &seed
+		}},
+		{"random flips, seed 6", func(cfg *Config) {
+			cfg.RandomFlip, cfg.RandomFlipVertical = true, true
+			seed := int64(6)
+			cfg.Seed = &seed
+		}},
+	}
+	for _, stream := range []bool{false, true} {
+		for _, tt := range tests {
+			cfg := DefaultConfig()
+			cfg.ImageFolder = dir
+			cfg.Rows, cfg.Cols = 3, 3
+			cfg.Dedup = true
+			cfg.Stream = stream
+			cfg.ManifestPath = filepath.Join(t.TempDir(), "manifest.json")
+			tt.decorate(&cfg)
+			var g Generator
+			if _, err := g.Generate(context.Background(), cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(cfg.ManifestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pages []manifestPage
+			if err := json.Unmarshal(data, &pages); err != nil {
+				t.Fatal(err)
+			}
+			placed := make(map[string]bool)
+			for _, cell := range pages[0].Cells {
+				placed[filepath.Base(cell.File)] = true
+			}
+			if len(placed) != 4 || placed["img_00_copy.jpg"] {
+				t.Errorf("stream=%v, %s: placed %v, want the 4 images without the copy", stream, tt.name, placed)
+			}
+		}
+	}
+}
+
+// TestGenerateNoFlatten checks that PNG cells keep the transparency of the source images with
+// NoFlatten, which gofpdf embeds as a soft mask, and that it is rejected where it cannot be kept.
+func TestGenerateNoFlatten(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(img, image.Rect(0, 0, 20, 30), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	f, err := os.Create(filepath.Join(dir, "half.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetProgress(false)
+
+	tests := []struct {
+		name      string
+		noFlatten bool
+		fit       string
+		wantMask  bool
+	}{
+		{"flattened", false, "stretch", false},
+		{"transparent", true, "stretch", true},
+		{"transparent letterbox", true, "contain", true},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.ImageFolder = dir
+		cfg.Rows, cfg.Cols = 1, 1
+		cfg.CellFormat = "png"
+		cfg.NoFlatten = tt.noFlatten
+		cfg.Fit = tt.fit
+		cfg.CornerRadius = 0.2
+		var g Generator
+		data, err := g.Generate(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.Contains(data, []byte("/SMask")); got != tt.wantMask {
+			t.Errorf("%s: PDF has a soft mask %v, want %v", tt.name, got, tt.wantMask)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		change func(*Config)
+	}{
+		{"jpeg cells", func(cfg *Config) { cfg.CellFormat = "jpeg" }},
+		{"grayscale", func(cfg *Config) { cfg.Grayscale = true }},
+		{"sepia", func(cfg *Config) { cfg.Sepia = true }},
+		{"brightness", func(cfg *Config) { cfg.Brightness = 10 }},
+	} {
+		cfg := DefaultConfig()
+		cfg.ImageFolder = dir
+		cfg.CellFormat = "png"
+		cfg.NoFlatten = true
+		tt.change(&cfg)
+		var g Generator
+		if _, err := g.Generate(context.Background(), cfg); err == nil {
+			t.Errorf("%s: got no error for keeping the transparency", tt.name)
+		}
+	}
+}
@@ -0,0 +1,2145 @@
+// Package gridpdf lays out the images of a folder, archive or list as shuffled grids on the
+// pages of a PDF. The imagesToGridPdf command is a thin command line front end to it: its
+// flags populate a Config, which a Generator turns into the PDF.
+package gridpdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jung-kurt/gofpdf/v2"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+)
+
+// captionHeight is the height (in mm) reserved at the bottom of each cell for the caption.
+const captionHeight = 4.0
+
+// supportedPageSizes lists the standard page sizes understood by gofpdf.
+var supportedPageSizes = []string{"A1", "A2", "A3", "A4", "A5", "A6", "Letter", "Legal", "Tabloid"}
+
+// Config holds all options for generating a PDF of image grids.
+type Config struct {
+	ImageFolder  string   // folder the images are loaded from
+	ExtraFolders []string // further folders whose images are added after those of ImageFolder
+	NumPages     int      // number of grid pages to generate
+
+	// Loading
+	ListFile  string   // text file listing the image paths to load in order, one per line, instead of ImageFolder
+	Recursive bool     // also load images from subfolders
+	Pattern   string   // only load images whose file name matches this glob pattern
+	Exclude   []string // skip images whose file name matches any of these glob patterns
+	Sort      string   // sort order of the loaded images (name, name-desc, mtime, size), "" keeps folder order
+	MaxImages int      // only load the first N images, 0 loads all
+	Workers   int      // maximum number of images decoded and resized concurrently
+	Dedup     bool     // place byte-identical duplicate images only once
+	Retries   int      // extra attempts to load an image after a failure that may be transient, e.g. on network mounts
+	Stream    bool     // resize images on demand while generating instead of preloading them all
+	CacheDir  string   // folder resized images are cached in between runs, "" disables the cache
+
+	// Image processing
+	DPI                  float64     // resolution of the embedded images, their pixel size follows from the cell size
+	Fit                  string      // how images are fitted into the square cells (stretch, contain, cover)
+	Align                string      // where contained images sit within their cells (center, top, bottom, left, right)
+	Interp               string      // resize interpolation (nearest, bilinear, bicubic, lanczos2, lanczos3)
+	Background           color.Color // letterbox color in contain mode and behind transparent areas
+	Quality              int         // JPEG quality of the embedded images (1-100)
+	CellFormat           string      // encoding of the embedded images (jpeg, png)
+	NoFlatten            bool        // keep the transparency of PNG cells instead of filling it with Background
+	Grayscale            bool        // convert the images to grayscale
+	Sepia                bool        // tone the images in sepia for an old-photo look, exclusive with Grayscale
+	NormalizeOrientation string      // turn the images that are not in this orientation (portrait, landscape) by 90°, "" keeps them
+	Brightness           float64     // brightness change in percent (-100 to 100), 0 leaves it unchanged
+	Contrast             float64     // contrast change in percent (at least -100), 0 leaves it unchanged
+	CornerRadius         float64     // radius of rounded image corners as a fraction of the cell size, 0 keeps them square
+
+	// Overlay
+	Overlay            bool        // overlay a square on each image
+	OverlaySize        float64     // size of the overlay square as a fraction of the image width
+	OverlayAlpha       float64     // opacity of the overlay square's fill (0-1), the border stays opaque
+	OverlayFill        color.Color // fill color of the overlay square
+	OverlayBorder      color.Color // border color of the overlay square
+	OverlayBorderWidth int         // width of the overlay square's border in pixels, 0 draws none
+	OverlayPos         string      // position of the overlay square (tl, tr, bl, br, center)
+	OverlayText        string      // text printed in the overlay square, {n} is replaced by the image's number
+	QR                 bool        // stamp a QR code on each image
+	QRData             string      // text encoded in the QR code, see qrData for the placeholders
+	QRSize             float64     // size of the QR code as a fraction of the image's shorter side
+	QRPos              string      // position of the QR code (tl, tr, bl, br, center)
+	LogoPath           string      // logo stamped in the overlay position instead of the plain square
+
+	// Layout
+	Rows         int     // number of rows in the grid on each page
+	Cols         int     // number of columns in the grid on each page
+	CellSize     float64 // width of the cells in mm, fitting as many rows and columns as the page holds, 0 uses Rows and Cols
+	AutoGrid     bool    // pick rows and columns so every image fits on a single page, ignoring Rows and Cols
+	ContactSheet bool    // place every image exactly once in load order, on as many pages as needed, ignoring NumPages
+	FillLast     string  // how cells left over on partially filled pages are used (blank, repeat, stretch)
+	Mosaic       bool    // tile the images edge to edge over the whole page, see withMosaic
+	PageSize     string  // page size of the PDF, one of supportedPageSizes
+	Landscape    bool    // use landscape instead of portrait orientation
+	CellAspect   float64 // width divided by height of the cells, 1 keeps them square
+	Orientations string  // orientation pattern of the grid pages, repeated, e.g. "PL" alternates portrait and landscape, "" uses Landscape
+	MarginTop    float64 // top margin in mm
+	MarginBottom float64 // bottom margin in mm
+	MarginLeft   float64 // left margin in mm
+	MarginRight  float64 // right margin in mm
+	CellSpacing  float64 // spacing between cells in mm
+	CellPadding  float64 // gap between the edges of each cell and its image in mm
+	Bleed        float64 // bleed in mm added to the page on all sides, the margins are measured from the trim line
+
+	// Shuffling
+	Seed          *int64 // seed for the shuffling, which also fixes the PDF dates; nil uses a time based seed
+	NoShuffle     bool   // place images in load order instead of shuffling them on every page
+	UniquePages   bool   // reshuffle until every page has a different image arrangement
+	StaticLayout  bool   // repeat the arrangement of the first page on every page
+	MaxReshuffles int    // maximum reshuffles per page when UniquePages is set
+
+	// Decorations
+	PageColor          color.Color // color the grid pages are filled with behind the images, nil leaves them white
+	PageImage          string      // JPEG, PNG or GIF covering each grid page behind the images, "" draws none
+	Title              string      // title of a cover page before the grid pages, "" adds no cover page
+	Subtitle           string      // subtitle printed below the title on the cover page
+	CellBorder         color.Color // color of the border drawn around each image, nil draws none
+	GridLines          color.Color // color of continuous lines between the grid cells, nil draws none
+	GridLineWidth      float64     // line width of the grid lines in mm
+	Shadow             color.Color // color of a drop shadow behind each image, nil draws none
+	ShadowOffset       float64     // offset of the drop shadow to the bottom right in mm
+	RandomRotate       bool        // rotate every placed image by a random angle for a scattered collage look
+	RandomRotateMax    float64     // maximum rotation in degrees, in either direction
+	RandomFlip         bool        // mirror a random half of the images horizontally, reproducible with Seed
+	RandomFlipVertical bool        // with RandomFlip, also mirror a random half of the images vertically
+	CellBorderWidth    float64     // line width of the cell border in mm
+	CropMarks          bool        // draw crop marks in the margins at the corners of the printable area
+	CropMarkLength     float64     // length of each crop mark line in mm
+	CropMarkOffset     float64     // gap between the printable area and the crop marks in mm
+	PageNumbers        bool        // print "Page X of N" in the bottom margin of each page
+	PageNumberAlign    string      // alignment of the page number (left, center, right)
+	FreeCenter         bool        // leave the center cell free instead of placing an image
+	FreeLabel          string      // text drawn in the free center cell
+	SerialNumbers      bool        // stamp an incrementing serial number on each page
+	SerialStart        int         // first serial number
+	SerialWidth        int         // zero-padded width of the serial number
+	Captions           bool        // print each image's file name beneath it
+	FontPath           string      // TrueType font of the captions, call sheet names and overlay text, "" uses the built-in fonts
+	CaptionFolders     bool        // prefix the captions with the name of the folder the image was loaded from
+	Watermark          string      // faint diagonal text drawn across every page
+	WatermarkSize      float64     // font size of the watermark in points
+	WatermarkAngle     float64     // rotation of the watermark in degrees, counter-clockwise
+
+	// Output
+	ManifestPath       string // JSON file recording the image placed in every cell, "" writes none
+	CallSheetPath      string // PDF listing every placed image with a thumbnail, "" writes none
+	ErrorLogPath       string // text file listing every image that failed to load with its error, "" writes none
+	SplitEvery         int    // write a separate PDF for every N pages, 0 writes a single PDF
+	MaxFileSize        int64  // size in bytes the PDF should fit in by lowering Quality, 0 for no limit
+	RestartPageNumbers bool   // restart the page numbers in every split PDF instead of continuing them
+
+	// Document metadata, empty fields are left unset
+	PDFTitle    string // title in the PDF document properties
+	PDFAuthor   string // author in the PDF document properties
+	PDFSubject  string // subject in the PDF document properties
+	PDFKeywords string // space separated keywords in the PDF document properties
+
+	// Protection, the PDF is encrypted when either password is set
+	Password      string // password needed to open the PDF
+	OwnerPassword string // password granting full access, "" uses a random one
+
+	// Progress callbacks, called from the goroutine running the generator. When nil,
+	// progress is printed to stdout.
+	LoadProgress func(done, total int) // images loaded so far, including failed ones
+	PageProgress func(done, total int) // pages generated so far
+}
+
+// DefaultConfig returns a Config with the default options used by the command line tool.
+func DefaultConfig() Config {
+	return Config{
+		NumPages:           1,
+		Workers:            runtime.NumCPU(),
+		DPI:                150,
+		Fit:                "stretch",
+		Align:              "center",
+		Interp:             "lanczos3",
+		Background:         color.White,
+		Quality:            jpeg.DefaultQuality,
+		CellFormat:         "jpeg",
+		OverlaySize:        0.2,
+		OverlayAlpha:       1,
+		OverlayFill:        color.White,
+		OverlayBorder:      color.RGBA{0, 0, 0, 255},
+		OverlayBorderWidth: 1,
+		OverlayPos:         "br",
+		QRData:             "{name}",
+		QRSize:             0.25,
+		QRPos:              "tl",
+		Rows:               5,
+		CellAspect:         1,
+		FillLast:           "blank",
+		Cols:               5,
+		PageSize:           "A4",
+		MarginTop:          10,
+		MarginBottom:       10,
+		MarginLeft:         10,
+		MarginRight:        10,
+		CellSpacing:        2,
+		MaxReshuffles:      100,
+		FreeLabel:          "FREE",
+		SerialWidth:        4,
+		WatermarkSize:      80,
+		WatermarkAngle:     45,
+		CellBorderWidth:    0.3,
+		RandomRotateMax:    15,
+		ShadowOffset:       1,
+		GridLineWidth:      0.2,
+		CropMarkLength:     5,
+		CropMarkOffset:     2,
+		PageNumberAlign:    "center",
+	}
+}
+
+// validate checks the options that cannot be fixed up silently.
+func (cfg Config) validate() error {
+	if cfg.NumPages < 1 && !cfg.ContactSheet {
+		return fmt.Errorf("number of pages must be at least 1, got %d", cfg.NumPages)
+	}
+	if cfg.DPI <= 0 {
+		return fmt.Errorf("DPI must be positive, got %g", cfg.DPI)
+	}
+	if strings.Trim(strings.ToUpper(cfg.Orientations), "PL") != "" {
+		return fmt.Errorf("orientation pattern %q may only contain P (portrait) and L (landscape)", cfg.Orientations)
+	}
+	if cfg.Retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", cfg.Retries)
+	}
+	if cfg.CellAspect <= 0 || math.IsInf(cfg.CellAspect, 0) {
+		return fmt.Errorf("cell aspect ratio must be positive, got %g", cfg.CellAspect)
+	}
+	if cfg.Rows < 1 || cfg.Cols < 1 {
+		return fmt.Errorf("grid rows and columns must be positive integers, got %dx%d", cfg.Rows, cfg.Cols)
+	}
+	switch cfg.CellFormat {
+	case "jpeg", "png":
+	default:
+		return fmt.Errorf("unsupported cell format %q, supported values are: jpeg, png", cfg.CellFormat)
+	}
+	if cfg.NoFlatten && cfg.CellFormat != "png" {
+		return fmt.Errorf("keeping the transparency requires the png cell format, JPEG has no alpha channel")
+	}
+	if cfg.NoFlatten && (cfg.Grayscale || cfg.Sepia || cfg.Brightness != 0 || cfg.Contrast != 0) {
+		return fmt.Errorf("keeping the transparency cannot be combined with grayscale, sepia, brightness or contrast, which produce opaque colors")
+	}
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		return fmt.Errorf("JPEG quality must be between 1 and 100, got %d", cfg.Quality)
+	}
+	if cfg.Brightness < -100 || cfg.Brightness > 100 {
+		return fmt.Errorf("brightness must be between -100 and 100 percent, got %g", cfg.Brightness)
+	}
+	if cfg.Contrast < -100 {
+		return fmt.Errorf("contrast must be at least -100 percent, got %g", cfg.Contrast)
+	}
+	switch cfg.NormalizeOrientation {
+	case "", "portrait", "landscape":
+	default:
+		return fmt.Errorf("unsupported orientation %q, supported values are: portrait, landscape", cfg.NormalizeOrientation)
+	}
+	if cfg.Grayscale && cfg.Sepia {
+		return fmt.Errorf("grayscale and sepia cannot be combined")
+	}
+	if cfg.CornerRadius < 0 || cfg.CornerRadius > 0.5 {
+		return fmt.Errorf("corner radius must be a fraction between 0 and 0.5, got %g", cfg.CornerRadius)
+	}
+	if cfg.OverlaySize <= 0 || cfg.OverlaySize > 1 {
+		return fmt.Errorf("overlay size must be a fraction between 0 and 1, got %g", cfg.OverlaySize)
+	}
+	if cfg.OverlayBorderWidth < 0 {
+		return fmt.Errorf("overlay border width must not be negative, got %d", cfg.OverlayBorderWidth)
+	}
+	if cfg.OverlayAlpha < 0 || cfg.OverlayAlpha > 1 {
+		return fmt.Errorf("overlay alpha must be between 0 and 1, got %g", cfg.OverlayAlpha)
+	}
+	switch cfg.OverlayPos {
+	case "tl", "tr", "bl", "br", "center":
+	default:
+		return fmt.Errorf("unsupported overlay position %q, supported values are: tl, tr, bl, br, center", cfg.OverlayPos)
+	}
+	if cfg.QR {
+		if cfg.QRData == "" {
+			return fmt.Errorf("qr data must not be empty")
+		}
+		if cfg.QRSize <= 0 || cfg.QRSize > 1 {
+			return fmt.Errorf("qr size must be a fraction between 0 and 1, got %g", cfg.QRSize)
+		}
+		switch cfg.QRPos {
+		case "tl", "tr", "bl", "br", "center":
+		default:
+			return fmt.Errorf("unsupported qr position %q, supported values are: tl, tr, bl, br, center", cfg.QRPos)
+		}
+	}
+	switch cfg.Fit {
+	case "stretch", "contain", "cover":
+	default:
+		return fmt.Errorf("unsupported fit mode %q, supported values are: stretch, contain, cover", cfg.Fit)
+	}
+	switch cfg.Align {
+	case "center", "top", "bottom", "left", "right":
+	default:
+		return fmt.Errorf("unsupported alignment %q, supported values are: center, top, bottom, left, right", cfg.Align)
+	}
+	if _, ok := interpolations[cfg.Interp]; !ok {
+		return fmt.Errorf("unsupported interpolation %q, supported values are: nearest, bilinear, bicubic, lanczos2, lanczos3", cfg.Interp)
+	}
+	switch cfg.FillLast {
+	case "blank", "repeat", "stretch":
+	default:
+		return fmt.Errorf("unsupported fill mode %q, supported values are: blank, repeat, stretch", cfg.FillLast)
+	}
+	if cfg.ContactSheet && (cfg.FreeCenter || cfg.UniquePages) {
+		return fmt.Errorf("a contact sheet cannot be combined with a free center cell or unique pages")
+	}
+	if len(cfg.ExtraFolders) > 0 && cfg.ListFile != "" {
+		return fmt.Errorf("image folders cannot be combined with a list file")
+	}
+	for _, folder := range append([]string{cfg.ImageFolder}, cfg.ExtraFolders...) {
+		if len(cfg.ExtraFolders) > 0 && isZipFile(folder) {
+			return fmt.Errorf("a ZIP archive cannot be combined with other image folders, got %s", folder)
+		}
+	}
+	if cfg.CellSize < 0 {
+		return fmt.Errorf("cell size must not be negative, got %g", cfg.CellSize)
+	}
+	if cfg.CellSize > 0 && (cfg.AutoGrid || cfg.Mosaic || cfg.Orientations != "") {
+		return fmt.Errorf("a cell size cannot be combined with an automatic grid, a mosaic or orientations")
+	}
+	if cfg.Mosaic && (cfg.AutoGrid || cfg.Orientations != "" || cfg.CellAspect != 1) {
+		return fmt.Errorf("a mosaic sets the cell shape from the page, it cannot be combined with an automatic grid, orientations or a cell aspect ratio")
+	}
+	if cfg.AutoGrid && cfg.FreeCenter {
+		return fmt.Errorf("a free center cell cannot be combined with an automatic grid")
+	}
+	if cfg.FreeCenter && (cfg.Rows%2 == 0 || cfg.Cols%2 == 0) {
+		return fmt.Errorf("a free center cell requires an odd number of rows and columns, got %dx%d", cfg.Rows, cfg.Cols)
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	if cfg.SplitEvery < 0 {
+		return fmt.Errorf("split every must not be negative, got %d", cfg.SplitEvery)
+	}
+	if cfg.MaxFileSize < 0 {
+		return fmt.Errorf("max file size must not be negative, got %d", cfg.MaxFileSize)
+	}
+	if cfg.MaxFileSize > 0 && cfg.SplitEvery > 0 {
+		return fmt.Errorf("a max file size cannot be combined with splitting the PDF")
+	}
+	if cfg.MaxFileSize > 0 && cfg.CellFormat != "jpeg" {
+		return fmt.Errorf("a max file size requires the jpeg cell format, the size is reduced by lowering the JPEG quality")
+	}
+	if cfg.MaxImages < 0 {
+		return fmt.Errorf("max images must not be negative, got %d", cfg.MaxImages)
+	}
+	switch cfg.Sort {
+	case "", "name", "name-desc", "mtime", "size":
+	default:
+		return fmt.Errorf("unsupported sort order %q, supported values are: name, name-desc, mtime, size", cfg.Sort)
+	}
+	if _, err := filepath.Match(cfg.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", cfg.Pattern, err)
+	}
+	for _, pattern := range cfg.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	if cfg.StaticLayout && (cfg.UniquePages || cfg.ContactSheet) {
+		return fmt.Errorf("a static layout cannot be combined with unique pages or a contact sheet")
+	}
+	if cfg.UniquePages && cfg.NoShuffle {
+		return fmt.Errorf("unique pages cannot be combined with disabled shuffling")
+	}
+	if cfg.MarginTop < 0 || cfg.MarginBottom < 0 || cfg.MarginLeft < 0 || cfg.MarginRight < 0 {
+		return fmt.Errorf("margins must not be negative, got top %g, bottom %g, left %g, right %g", cfg.MarginTop, cfg.MarginBottom, cfg.MarginLeft, cfg.MarginRight)
+	}
+	if cfg.Bleed < 0 {
+		return fmt.Errorf("bleed must not be negative, got %g", cfg.Bleed)
+	}
+	if cfg.CellSpacing < 0 {
+		return fmt.Errorf("cell spacing must not be negative, got %g", cfg.CellSpacing)
+	}
+	if cfg.CellPadding < 0 {
+		return fmt.Errorf("cell padding must not be negative, got %g", cfg.CellPadding)
+	}
+	if cfg.RandomRotate && (cfg.RandomRotateMax <= 0 || cfg.RandomRotateMax > 45) {
+		return fmt.Errorf("random rotation must be between 0 and 45 degrees, got %g", cfg.RandomRotateMax)
+	}
+	if cfg.GridLines != nil && cfg.GridLineWidth <= 0 {
+		return fmt.Errorf("grid line width must be positive, got %g", cfg.GridLineWidth)
+	}
+	if cfg.Shadow != nil && cfg.ShadowOffset <= 0 {
+		return fmt.Errorf("shadow offset must be positive, got %g", cfg.ShadowOffset)
+	}
+	if cfg.CellBorder != nil && cfg.CellBorderWidth <= 0 {
+		return fmt.Errorf("cell border width must be positive, got %g", cfg.CellBorderWidth)
+	}
+	if cfg.CropMarks && (cfg.CropMarkLength <= 0 || cfg.CropMarkOffset < 0) {
+		return fmt.Errorf("crop mark length must be positive and offset must not be negative, got length %g, offset %g", cfg.CropMarkLength, cfg.CropMarkOffset)
+	}
+	switch cfg.PageNumberAlign {
+	case "left", "center", "right":
+	default:
+		return fmt.Errorf("unsupported page number alignment %q, supported values are: left, center, right", cfg.PageNumberAlign)
+	}
+	if !isSupportedPageSize(cfg.PageSize) {
+		return fmt.Errorf("unsupported page size %q, supported values are: %s", cfg.PageSize, strings.Join(supportedPageSizes, ", "))
+	}
+	return nil
+}
+
+// gridImage is a resized image ready to be placed in the grid.
+type gridImage struct {
+	name    string          // file name without extension, used for captions
+	path    string          // path of the source file
+	modTime time.Time       // modification time of the source file
+	size    int64           // size in bytes of the source file
+	data    []byte          // image data encoded in the cell format, nil when images are streamed
+	sum     [sha1.Size]byte // SHA-1 of the source file, only computed with Dedup
+	weight  float64         // relative frequency the image is placed with, from the list file
+}
+
+// Generator generates PDFs of shuffled image grids. Its zero value is ready to use.
+//
+// A Generator keeps the state of the run in progress (the options, the shuffling and the
+// loaded images), so it is not safe for concurrent use: call its methods one at a time, or
+// use a Generator per goroutine to generate several PDFs at once. The log level and output
+// (see SetLogLevel) are shared by all Generators.
+type Generator struct {
+	cfg  Config
+	logo image.Image // the logo, decoded and resized once to the overlay size
+	rng  *rand.Rand
+
+	seed     int64 // seed of rng, Seed or drawn from the clock
+	flipSeed int64 // seed of the per-image choices of RandomFlip
+
+	imageWidth  uint // size in pixels the images are resized to
+	imageHeight uint
+
+	failed  map[string]bool    // paths of streamed images that failed to load, so they are not retried
+	archive *zip.ReadCloser    // ZIP archive the images are read from, nil when reading a folder
+	weights map[string]float64 // weights of the listed images by path, nil when they all weigh the same
+	numbers map[string]int     // 1-based position of each image among the source files, for OverlayText
+	folders map[string]string  // name of the folder each image was loaded from by path, for CaptionFolders
+
+	overlayFont *opentype.Font // font of OverlayText, FontPath or Go Bold, nil when neither is used
+	fontData    []byte         // contents of FontPath, nil without it
+}
+
+// Generate loads and resizes the images of cfg.ImageFolder and returns the generated PDF.
+// It stops early and returns the context's error when ctx is canceled.
+func (g *Generator) Generate(ctx context.Context, cfg Config) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := g.GenerateTo(ctx, cfg, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GenerateTo loads and resizes the images of cfg.ImageFolder and writes the generated PDF to w.
+func (g *Generator) GenerateTo(ctx context.Context, cfg Config, w io.Writer) error {
+	images, err := g.prepare(ctx, cfg)
+	defer g.closeArchive()
+	if err != nil {
+		return err
+	}
+	if cfg.MaxFileSize > 0 {
+		data, err := g.generateWithinSize(ctx, images)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return g.generatePDFTo(ctx, images, w)
+}
+
+// GenerateFile loads and resizes the images of cfg.ImageFolder and saves the generated PDF
+// to outputPDF.
+func (g *Generator) GenerateFile(ctx context.Context, cfg Config, outputPDF string) error {
+	images, err := g.prepare(ctx, cfg)
+	defer g.closeArchive()
+	if err != nil {
+		return err
+	}
+	if cfg.MaxFileSize > 0 {
+		data, err := g.generateWithinSize(ctx, images)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(outputPDF, data, 0o644); err != nil {
+			return fmt.Errorf("failed to save PDF: %w", err)
+		}
+		return nil
+	}
+	return g.generatePDF(ctx, images, outputPDF)
+}
+
+// Steps of the JPEG quality while fitting the PDF in MaxFileSize
+const (
+	fitQualityStep = 10 // decrease per attempt
+	fitQualityMin  = 10 // lowest quality tried, below it the images fall apart
+)
+
+// generateWithinSize generates the PDF in memory, lowering the JPEG quality and re-encoding
+// the images until it fits in MaxFileSize. Every attempt uses the same layout. When even the
+// lowest quality is too large, it logs a warning and returns the smallest PDF.
+func (g *Generator) generateWithinSize(ctx context.Context, images []gridImage) ([]byte, error) {
+	seed := g.rng.Int63()
+	for {
+		g.rng = rand.New(rand.NewSource(seed))
+		var buf bytes.Buffer
+		if err := g.generatePDFTo(ctx, images, &buf); err != nil {
+			return nil, err
+		}
+		size := int64(buf.Len())
+		if size <= g.cfg.MaxFileSize {
+			logInfof("PDF is %s at JPEG quality %d", formatFileSize(size), g.cfg.Quality)
+			return buf.Bytes(), nil
+		}
+		if g.cfg.Quality <= fitQualityMin {
+			logWarnf("PDF is %s even at JPEG quality %d, more than the maximum of %s", formatFileSize(size), g.cfg.Quality, formatFileSize(g.cfg.MaxFileSize))
+			return buf.Bytes(), nil
+		}
+
+		g.cfg.Quality = max(g.cfg.Quality-fitQualityStep, fitQualityMin)
+		logInfof("PDF is %s, more than %s, re-encoding the images at JPEG quality %d", formatFileSize(size), formatFileSize(g.cfg.MaxFileSize), g.cfg.Quality)
+		// Streamed images are encoded when they are placed, at the quality of that attempt
+		if !g.cfg.Stream {
+			var err error
+			if images, err = g.reencodeImages(ctx, images); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// reencodeImages resizes the images again with the current settings and returns copies
+// holding the new data.
+func (g *Generator) reencodeImages(ctx context.Context, images []gridImage) ([]gridImage, error) {
+	reencoded := append([]gridImage(nil), images...)
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, g.cfg.Workers)
+	for i := range reencoded {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func(img *gridImage, err *error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			img.data, *err = g.resizeImageCached(img.path, img.modTime, img.size)
+		}(&reencoded[i], &errs[i])
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encode image %s: %w", images[i].path, err)
+		}
+	}
+	return reencoded, nil
+}
+
+// formatFileSize formats a size in bytes with a decimal unit, e.g. 12.3 MB.
+func formatFileSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, prefix := float64(size)/unit, 0
+	for value >= unit && prefix < 2 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMG"[prefix])
+}
+
+// Plan describes the PDF that would be generated for a Config.
+type Plan struct {
+	Images       int     // number of images found
+	Rows         int     // number of rows in the grid on each page
+	Cols         int     // number of columns in the grid on each page
+	CellSize     float64 // width of the cells in mm
+	CellHeight   float64 // height of the cells in mm, equal to CellSize unless CellAspect is set
+	CellsPerPage int     // number of images placed on each page
+	Pages        int     // number of grid pages
+}
+
+// DryRun finds the images of cfg.ImageFolder and computes the layout of the PDF without
+// resizing the images or generating the PDF. Images that would fail to load are counted.
+func (g *Generator) DryRun(ctx context.Context, cfg Config) (Plan, error) {
+	cfg.Stream = true // Only list the images, streaming resizes them when they are placed
+	images, err := g.prepare(ctx, cfg)
+	defer g.closeArchive()
+	if err != nil {
+		return Plan{}, err
+	}
+	pageWidth, pageHeight := newPDF(g.cfg).GetPageSize()
+	return g.layout(len(images), pageWidth, pageHeight)
+}
+
+// WriteThumbnails loads and resizes the images of cfg.ImageFolder like Generate, but writes
+// the processed images to dir instead of generating a PDF. Each file keeps the name of its
+// source image with the extension of the cell format, numbered when several images share a name.
+func (g *Generator) WriteThumbnails(ctx context.Context, cfg Config, dir string) error {
+	cfg.Stream = false // The resized data is needed up front
+	images, err := g.prepare(ctx, cfg)
+	defer g.closeArchive()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create thumbnail folder: %w", err)
+	}
+
+	used := make(map[string]bool)
+	for _, img := range images {
+		ext := g.cellExt()
+		name := img.name + ext
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d%s", img.name, n, ext)
+		}
+		used[strings.ToLower(name)] = true
+		if err := os.WriteFile(filepath.Join(dir, name), img.data, 0o644); err != nil {
+			return fmt.Errorf("failed to write thumbnail: %w", err)
+		}
+	}
+	return nil
+}
+
+// prepare validates cfg, sets up the generator for it and loads the images.
+func (g *Generator) prepare(ctx context.Context, cfg Config) ([]gridImage, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// Everything below reads the adjusted options, e.g. Mosaic turns off the logo
+	cfg = cfg.withMosaic().withBleed()
+	if cfg.Mosaic {
+		// Only known once the bleed has been added to the page
+		cfg.CellAspect = mosaicCellAspect(cfg)
+	}
+	if cfg.CellSize > 0 {
+		var err error
+		if cfg, err = cfg.withCellSize(); err != nil {
+			return nil, err
+		}
+		logInfof("Fitting a %dx%d grid of %g mm cells on each page", cfg.Rows, cfg.Cols, cfg.CellSize)
+	} else if cfg.CellAspect != 1 && !cfg.Mosaic && !cfg.AutoGrid {
+		cfg = cfg.withAspectRows()
+		logInfof("Fitting %d rows of %d columns on each page for the cell aspect ratio", cfg.Rows, cfg.Cols)
+	}
+	g.cfg = cfg
+
+	g.failed = make(map[string]bool)
+
+	if cfg.CacheDir != "" {
+		if err := os.MkdirAll(cfg.CacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+
+	if cfg.Seed != nil {
+		g.seed = *cfg.Seed
+	} else {
+		g.seed = time.Now().UnixNano()
+		logInfof("Using random seed %d, pass --seed %d to reproduce this run", g.seed, g.seed)
+	}
+	g.rng = rand.New(rand.NewSource(g.seed))
+	if cfg.RandomFlip {
+		g.flipSeed = g.rng.Int63()
+	}
+
+	source := "folder"
+	if cfg.ListFile != "" {
+		source = "list"
+		logInfof("Loading images from list: %s", cfg.ListFile)
+	} else if isZipFile(cfg.ImageFolder) {
+		source = "archive"
+		logInfof("Loading images from archive: %s", cfg.ImageFolder)
+		if err := g.openArchive(cfg.ImageFolder); err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+	} else if len(cfg.ExtraFolders) > 0 {
+		source = "folders"
+		logInfof("Loading images from folders: %s", strings.Join(append([]string{cfg.ImageFolder}, cfg.ExtraFolders...), ", "))
+	} else {
+		logInfof("Loading images from folder: %s", cfg.ImageFolder)
+	}
+	files, err := g.listSourceFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load images from %s: %w", source, err)
+	}
+	logDebugf("Found %d image files", len(files))
+
+	if cfg.QR && len(files) > 0 {
+		// A payload too long for a QR code fails for every image alike, so report it once
+		// instead of skipping all of them
+		if _, err := qrcode.New(g.qrData(files[0]), qrcode.Medium); err != nil {
+			return nil, fmt.Errorf("failed to create QR code of %s: %w", files[0], err)
+		}
+	}
+
+	g.imageWidth, g.imageHeight = g.imagePixelSize(len(files))
+	logInfof("Resizing images to %dx%d pixels (%g DPI)", g.imageWidth, g.imageHeight, cfg.DPI)
+
+	g.logo = nil
+	if cfg.LogoPath != "" {
+		logo, err := loadLogo(cfg.LogoPath, uint(cfg.OverlaySize*float64(g.imageWidth)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load logo: %w", err)
+		}
+		g.logo = logo
+	}
+
+	g.fontData, g.overlayFont = nil, nil
+	if cfg.FontPath != "" {
+		// Parse the font up front, gofpdf would only fail when writing the PDF
+		data, err := os.ReadFile(cfg.FontPath)
+		if err == nil {
+			g.overlayFont, err = opentype.Parse(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load font: %w", err)
+		}
+		g.fontData = data
+	} else if cfg.OverlayText != "" {
+		font, err := opentype.Parse(gobold.TTF)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load overlay font: %w", err)
+		}
+		g.overlayFont = font
+	}
+
+	images, err := g.loadAndResizeImages(ctx, files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load images from %s: %w", source, err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images found in the specified %s", source)
+	}
+	return images, nil
+}
+
+func isSupportedPageSize(size string) bool {
+	for _, supported := range supportedPageSizes {
+		if strings.EqualFold(size, supported) {
+			return true
+		}
+	}
+	return false
+}
+
+// listSourceFiles returns the paths of the images to load from the list file, the archive
+// or the folders in order, limited to MaxImages. It records the folder of every image for
+// CaptionFolders: the folder it was listed from, the folder of a list entry or the archive.
+func (g *Generator) listSourceFiles() ([]string, error) {
+	var files []string
+	var err error
+	g.weights = nil
+	g.folders = make(map[string]string)
+	if g.cfg.ListFile != "" {
+		files, g.weights, err = readImageList(g.cfg.ListFile)
+		for _, file := range files {
+			g.folders[file] = folderName(filepath.Dir(file))
+		}
+	} else if g.archive != nil {
+		files = g.listArchiveFiles()
+		for _, file := range files {
+			g.folders[file] = strings.TrimSuffix(folderName(g.cfg.ImageFolder), filepath.Ext(g.cfg.ImageFolder))
+		}
+	} else {
+		for _, folder := range append([]string{g.cfg.ImageFolder}, g.cfg.ExtraFolders...) {
+			var paths []string
+			if paths, err = g.listImageFiles(folder); err != nil {
+				break
+			}
+			for _, path := range paths {
+				g.folders[path] = folderName(folder)
+			}
+			// Files of the same name in different folders have different paths, so both are kept
+			files = append(files, paths...)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only queue the first N files, images that fail to load are not replaced
+	if g.cfg.MaxImages > 0 && len(files) > g.cfg.MaxImages {
+		files = files[:g.cfg.MaxImages]
+	}
+
+	// Number the images in the source order, so the numbers stay the same when shuffling
+	g.numbers = make(map[string]int, len(files))
+	for i, file := range files {
+		g.numbers[file] = i + 1
+	}
+	return files, nil
+}
+
+// folderName returns the name of the folder at path, resolving "." and the like.
+func folderName(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.Base(path)
+}
+
+// imagePixelSize returns the width and height in pixels the images are resized to, so they
+// fill their cells at the configured DPI without being scaled again in the PDF. An automatic
+// grid is sized for all numImages images, even if some of them fail to load later.
+func (g *Generator) imagePixelSize(numImages int) (width, height uint) {
+	rows, cols := g.cfg.Rows, g.cfg.Cols
+	if g.cfg.AutoGrid && numImages > 0 {
+		rows, cols = autoGrid(numImages)
+	}
+	pageWidth, pageHeight := newPDF(g.cfg).GetPageSize()
+	// With mixed orientations the images are sized for the larger cells, so none are upscaled
+	_, cellWidth := g.cellSizeRange(rows, cols, pageWidth, pageHeight)
+	pixels := func(mm float64) uint {
+		return uint(math.Max(1, math.Round(mm/25.4*g.cfg.DPI)))
+	}
+	return pixels(cellWidth), pixels(cellWidth / g.cfg.CellAspect)
+}
+
+func (g *Generator) loadAndResizeImages(ctx context.Context, files []string) ([]gridImage, error) {
+	// Results are sent with the index of their file, so the folder order is kept
+	// no matter in which order the workers finish
+	type loadResult struct {
+		index int
+		img   *gridImage // nil when the image failed to load
+		err   error      // why the image failed to load
+	}
+
+	var wg sync.WaitGroup
+	imageChan := make(chan loadResult, len(files))
+
+	totalFiles := len(files)
+
+	// Bound the number of images held in memory while decoding and resizing
+	sem := make(chan struct{}, g.cfg.Workers)
+
+queue:
+	for i, imagePath := range files {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break queue
+		}
+		wg.Add(1)
+		go func(index int, imagePath string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			img, err := g.loadImage(imagePath)
+			result := loadResult{index: index, img: img, err: err}
+			select {
+			case imageChan <- result:
+			case <-ctx.Done():
+			}
+		}(i, imagePath)
+	}
+
+	go func() {
+		wg.Wait()
+		close(imageChan)
+	}()
+
+	action := "Loaded and resized"
+	if g.cfg.Stream {
+		action = "Found" // Streamed images are resized later, when they are placed
+	}
+
+	// Progress is reported here rather than in the workers, so callbacks run on the caller's goroutine
+	results := make([]*gridImage, len(files))
+	errs := make([]error, len(files))
+	processedFiles := 0
+	for result := range imageChan {
+		results[result.index] = result.img
+		errs[result.index] = result.err
+		if result.err != nil {
+			logWarnf("Failed to process image %s: %v", files[result.index], result.err)
+		}
+		processedFiles++
+		if g.cfg.LoadProgress != nil {
+			g.cfg.LoadProgress(processedFiles, totalFiles)
+		} else {
+			progressf("\r%s %d/%d images", action, processedFiles, totalFiles)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Compact out the images that failed to load
+	var images []gridImage
+	for _, img := range results {
+		if img != nil {
+			images = append(images, *img)
+		}
+	}
+
+	if g.cfg.Dedup {
+		images = g.dedupImages(images)
+	}
+	sortImages(images, g.cfg.Sort)
+
+	if g.cfg.LoadProgress == nil {
+		progressf("\n%s %d images\n", action, len(images)) // New line after all images are processed
+	}
+	if err := g.reportFailures(files, errs); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// dedupImages removes the images whose source file duplicates an earlier one, keeping the
+// first.
+func (g *Generator) dedupImages(images []gridImage) []gridImage {
+	seen := make(map[[sha1.Size]byte]bool)
+	unique := images[:0]
+	for _, img := range images {
+		if seen[img.sum] {
+			logDebugf("Skipping duplicate image %s", img.path)
+			continue
+		}
+		seen[img.sum] = true
+		unique = append(unique, img)
+	}
+	if removed := len(images) - len(unique); removed > 0 {
+		logInfof("Removed %d duplicate images", removed)
+	}
+	return unique
+}
+
+// maxReportedFailures is the number of failed images named in the summary after loading.
+const maxReportedFailures = 5
+
+// reportFailures logs how many of files failed to load, as recorded in errs, naming the
+// first few, and writes all of them with their errors to ErrorLogPath if it is set.
+func (g *Generator) reportFailures(files []string, errs []error) error {
+	var failed []string
+	var report strings.Builder
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, files[i])
+			fmt.Fprintf(&report, "%s: %v\n", files[i], err)
+		}
+	}
+
+	if len(failed) > 0 {
+		names := failed
+		if len(names) > maxReportedFailures {
+			names = append(names[:maxReportedFailures:maxReportedFailures], "...")
+		}
+		logWarnf("%d of %d images failed to load: %s", len(failed), len(files), strings.Join(names, ", "))
+	}
+
+	// The log is written even without failures, so a stale one from an earlier run is not mistaken for this one
+	if g.cfg.ErrorLogPath != "" {
+		if err := os.WriteFile(g.cfg.ErrorLogPath, []byte(report.String()), 0o644); err != nil {
+			return fmt.Errorf("failed to write error log: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadImage resizes the image at imagePath. When images are streamed only the file is
+// checked, resizing happens when it is first placed.
+func (g *Generator) loadImage(imagePath string) (*gridImage, error) {
+	info, err := g.statImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	var imgData []byte
+	if !g.cfg.Stream {
+		imgData, err = g.resizeImageCached(imagePath, info.ModTime(), info.Size())
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Duplicates are found by their source files, the cells differ by the decorations derived
+	// from the file name (flips, overlay labels and QR codes)
+	var sum [sha1.Size]byte
+	if g.cfg.Dedup {
+		data, err := g.readImageFile(imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image for deduplication: %w", err)
+		}
+		sum = sha1.Sum(data)
+	}
+	weight := 1.0
+	if w, ok := g.weights[imagePath]; ok {
+		weight = w
+	}
+	base := filepath.Base(imagePath)
+	return &gridImage{
+		name:    strings.TrimSuffix(base, filepath.Ext(base)),
+		path:    imagePath,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		data:    imgData,
+		sum:     sum,
+		weight:  weight,
+	}, nil
+}
+
+// sortImages sorts the images in place by the given order (name, name-desc, mtime or size).
+// Any other order, including "", leaves the images as loaded.
+func sortImages(images []gridImage, order string) {
+	var less func(a, b gridImage) bool
+	switch order {
+	case "name":
+		less = func(a, b gridImage) bool { return a.path < b.path }
+	case "name-desc":
+		less = func(a, b gridImage) bool { return a.path > b.path }
+	case "mtime":
+		less = func(a, b gridImage) bool { return a.modTime.Before(b.modTime) }
+	case "size":
+		less = func(a, b gridImage) bool { return a.size < b.size }
+	default:
+		return
+	}
+	sort.SliceStable(images, func(i, j int) bool { return less(images[i], images[j]) })
+}
+
+// listImageFiles returns the paths of the image files in folder, including its
+// subfolders when Recursive is set.
+func (g *Generator) listImageFiles(folder string) ([]string, error) {
+	var paths []string
+
+	if !g.cfg.Recursive {
+		files, err := os.ReadDir(folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !file.IsDir() && g.includeFile(file.Name()) {
+				paths = append(paths, filepath.Join(folder, file.Name()))
+			}
+		}
+		return paths, nil
+	}
+
+	// WalkDir does not follow symbolic links to directories, so symlink loops cannot recurse forever
+	err := filepath.WalkDir(folder, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && g.includeFile(d.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// readImageList returns the image paths listed in the file at path, one per line and in
+// order. Empty lines and lines starting with # are ignored, and paths that do not exist or
+// are not images are logged and skipped. A line may end in a weight after the path, the
+// weights are returned by path (nil when no line has one) and images of weight 0 are left out.
+func readImageList(path string) ([]string, map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var paths []string
+	var weights map[string]float64
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		weight := 1.0
+		if i := strings.LastIndexAny(line, " \t"); i >= 0 {
+			if w, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+				if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+					return nil, nil, fmt.Errorf("line %d: weight must be a non-negative number, got %s", n+1, line[i+1:])
+				}
+				if weights == nil {
+					weights = make(map[string]float64)
+				}
+				weight, line = w, strings.TrimSpace(line[:i])
+			}
+		}
+		if weight == 0 {
+			continue // Never placed, so there is no point in loading it
+		}
+		info, err := os.Stat(line)
+		if err != nil {
+			logWarnf("Skipping listed image %s: %v", line, err)
+			continue
+		}
+		if info.IsDir() || !isImageFile(line) {
+			logWarnf("Skipping listed image %s: not an image file", line)
+			continue
+		}
+		if weights != nil {
+			weights[line] = weight
+		}
+		paths = append(paths, line)
+	}
+	return paths, weights, nil
+}
+
+// includeFile reports whether the file name is an image that passes the Pattern and
+// Exclude filters.
+func (g *Generator) includeFile(name string) bool {
+	if !isImageFile(name) {
+		return false
+	}
+	for _, pattern := range g.cfg.Exclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if g.cfg.Pattern != "" {
+		matched, _ := filepath.Match(g.cfg.Pattern, name) // The pattern is validated up front
+		return matched
+	}
+	return true
+}
+
+func isImageFile(filename string) bool {
+	ext := filepath.Ext(filename)
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tif", ".tiff", ".heic", ".heif":
+		return true
+	default:
+		return false
+	}
+}
+
+// generatePDF generates the PDF and saves it to outputPDF. When SplitEvery is set, the
+// parts are saved next to it, see SplitFileName.
+func (g *Generator) generatePDF(ctx context.Context, images []gridImage, outputPDF string) error {
+	var file *os.File
+	var saved []string
+	closeFile := func() error {
+		if file == nil {
+			return nil
+		}
+		err := file.Close()
+		file = nil
+		if err != nil {
+			return fmt.Errorf("failed to save PDF: %w", err)
+		}
+		return nil
+	}
+
+	err := g.generatePDFParts(ctx, images, func(part int) (io.Writer, error) {
+		if err := closeFile(); err != nil {
+			return nil, err
+		}
+		name := outputPDF
+		if g.cfg.SplitEvery > 0 {
+			name = SplitFileName(outputPDF, part)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save PDF: %w", err)
+		}
+		file = f
+		saved = append(saved, name)
+		return f, nil
+	})
+	if closeErr := closeFile(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		for _, name := range saved {
+			os.Remove(name) // Don't leave truncated or partial PDFs behind
+		}
+	}
+	return err
+}
+
+// SplitFileName returns the file name of the given part of a split PDF, numbering the
+// parts after the base name of outputPDF, e.g. output_001.pdf.
+func SplitFileName(outputPDF string, part int) string {
+	ext := filepath.Ext(outputPDF)
+	return fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(outputPDF, ext), part, ext)
+}
+
+// generatePDFTo lays out the images on the grid pages and writes the PDF to w.
+func (g *Generator) generatePDFTo(ctx context.Context, images []gridImage, w io.Writer) error {
+	return g.generatePDFParts(ctx, images, func(part int) (io.Writer, error) {
+		if part > 1 {
+			return nil, fmt.Errorf("splitting the PDF requires saving it to files")
+		}
+		return w, nil
+	})
+}
+
+// newDocument creates an empty PDF with the page setup, metadata, protection and font of cfg.
+func (g *Generator) newDocument(cfg Config) *gofpdf.Fpdf {
+	pdf := newPDF(cfg)
+	setPDFMetadata(pdf, cfg)
+	// Record the seed in the document properties, so the PDF can be reproduced from it
+	pdf.SetCreator(fmt.Sprintf("imagesToGridPdf (seed %d)", g.seed), false)
+	if cfg.Password != "" || cfg.OwnerPassword != "" {
+		// Opening with the user password allows printing and copying, but not editing
+		pdf.SetProtection(gofpdf.CnProtectPrint|gofpdf.CnProtectCopy, cfg.Password, cfg.OwnerPassword)
+	}
+	g.addFont(pdf)
+	return pdf
+}
+
+// generatePDFParts lays out the images on the grid pages. Every finished PDF is written to
+// the writer returned by nextPart, which is called with the part number (starting at 1)
+// once for a single PDF and once per part when SplitEvery is set.
+func (g *Generator) generatePDFParts(ctx context.Context, images []gridImage, nextPart func(part int) (io.Writer, error)) error {
+	cfg := g.cfg
+
+	pdf := g.newDocument(cfg)
+	pageWidth, pageHeight := pdf.GetPageSize()
+	pageSize := fmt.Sprintf("%.0fx%.0f mm", pageWidth, pageHeight) // For the summary, before orientations change it
+
+	plan, err := g.layout(len(images), pageWidth, pageHeight)
+	if err != nil {
+		return err
+	}
+	cfg.Rows, cfg.Cols, cfg.NumPages = plan.Rows, plan.Cols, plan.Pages
+	cellSize, cellHeight, cellsPerPage := plan.CellSize, plan.CellHeight, plan.CellsPerPage
+
+	if cfg.PageProgress == nil {
+		progressf("\nGenerating PDF with %d pages\n", cfg.NumPages)
+	}
+
+	// Shuffle a permutation of image indices rather than the images themselves,
+	// so page layouts can be compared by image identity
+	order := make([]int, len(images))
+	for i := range order {
+		order[i] = i
+	}
+	seenLayouts := make(map[string]bool)
+	var manifest []manifestPage
+	placed := make([]bool, len(images)) // Images placed at least once, for the call sheet
+	filledCells := 0
+
+	if cfg.Title != "" {
+		drawCoverPage(pdf, cfg.Title, cfg.Subtitle, pageWidth, pageHeight)
+	}
+	reshuffles := 0
+
+	part := 1
+	writePart := func() error {
+		w, err := nextPart(part)
+		if err != nil {
+			return err
+		}
+		if err := pdf.Output(w); err != nil {
+			return fmt.Errorf("failed to write PDF: %w", err)
+		}
+		return nil
+	}
+
+	for i := 0; i < cfg.NumPages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if cfg.SplitEvery > 0 && i > 0 && i%cfg.SplitEvery == 0 {
+			if err := writePart(); err != nil {
+				return err
+			}
+			part++
+			pdf = g.newDocument(cfg)
+		}
+
+		if cfg.Orientations != "" {
+			// The grid keeps its rows and columns, so only the cell size changes with the orientation
+			pdf.AddPageFormat(g.pageOrientation(i), pageFormat(cfg))
+			pageWidth, pageHeight = pdf.GetPageSize()
+			cellSize = gridCellSize(cfg, cfg.Rows, cfg.Cols, pageWidth, pageHeight)
+			cellHeight = cellSize / cfg.CellAspect
+		} else {
+			pdf.AddPage()
+		}
+		pdf.SetMargins(cfg.MarginLeft, cfg.MarginTop, cfg.MarginRight)
+		if err := g.drawPageBackground(pdf, pageWidth, pageHeight); err != nil {
+			return err
+		}
+
+		var indices []int
+		for attempt := 0; ; attempt++ {
+			// Shuffle images, a static layout is only shuffled for the first page
+			if !cfg.NoShuffle && !cfg.ContactSheet && (!cfg.StaticLayout || i == 0) {
+				if g.weights != nil {
+					g.weightedShuffle(order, images)
+				} else {
+					g.rng.Shuffle(len(order), func(i, j int) {
+						order[i], order[j] = order[j], order[i]
+					})
+				}
+			}
+
+			layoutPage := i
+			if cfg.StaticLayout || (g.weights != nil && !cfg.NoShuffle && !cfg.ContactSheet) {
+				// Repeat the arrangement of the first page, or take the images a weighted
+				// shuffle put first rather than tiling all of them across the pages
+				layoutPage = 0
+			}
+			indices = pageImageIndices(layoutPage, cellsPerPage, len(images))
+			if cfg.ContactSheet && len(images)-i*cellsPerPage < cellsPerPage {
+				indices = indices[:len(images)-i*cellsPerPage] // The last page is only partially filled
+			}
+			if cfg.FillLast == "repeat" {
+				// Reuse the images that follow in order, wrapping around to the first ones
+				for cell := len(indices); cell < imageCells(cfg, cfg.Rows, cfg.Cols); cell++ {
+					indices = append(indices, (layoutPage*cellsPerPage+cell)%len(images))
+				}
+			}
+			for cell, idx := range indices {
+				indices[cell] = order[idx]
+			}
+			if !cfg.UniquePages {
+				break
+			}
+
+			key := fmt.Sprint(indices)
+			if !seenLayouts[key] {
+				seenLayouts[key] = true
+				break
+			}
+			if attempt >= cfg.MaxReshuffles {
+				return fmt.Errorf("failed to find a unique layout for page %d after %d reshuffles", i+1, attempt)
+			}
+			reshuffles++
+		}
+
+		page := manifestPage{Page: i + 1}
+		if cfg.SerialNumbers {
+			serial := cfg.SerialStart + i
+			page.Serial = &serial
+		}
+
+		// A partially filled page can use a grid of larger cells for the images it has
+		rows, cols, cellW, cellH := cfg.Rows, cfg.Cols, cellSize, cellHeight
+		if cfg.FillLast == "stretch" && len(indices) < imageCells(cfg, rows, cols) {
+			rows, cols, cellW = largestCellGrid(cfg, len(indices), pageWidth, pageHeight)
+			cellH = cellW / cfg.CellAspect
+		}
+
+		if cfg.Stream {
+			// Resize the new images of the page concurrently, so placing them below only
+			// references them. The free center cell gets no image.
+			var pageImages []gridImage
+			for _, idx := range indices[:min(len(indices), imageCells(cfg, rows, cols))] {
+				pageImages = append(pageImages, images[idx])
+			}
+			g.registerStreamed(pdf, pageImages)
+		}
+
+		// Add images to the grid
+		for row := 0; row < rows; row++ {
+			for col := 0; col < cols; col++ {
+				x := cfg.MarginLeft + float64(col)*(cellW+cfg.CellSpacing)
+				y := cfg.MarginTop + float64(row)*(cellH+cfg.CellSpacing)
+				if cfg.FreeCenter && row == rows/2 && col == cols/2 {
+					drawFreeCell(pdf, cfg.FreeLabel, x, y, cellW, cellH)
+					continue
+				}
+				cell := row*cols + col
+				if cfg.FreeCenter && cell > rows/2*cols+cols/2 {
+					cell-- // The free center takes no image, so the cells after it move up one image
+				}
+				if cell >= len(indices) {
+					continue // Blank cell on a partially filled page
+				}
+				img := images[indices[cell]]
+				if cfg.Captions {
+					// Shrink the image to leave room for the caption below it
+					height := cellH - captionHeight
+					width := height * cfg.CellAspect
+					g.placeImage(pdf, img, x+(cellW-width)/2, y, width, height)
+					caption := img.name
+					if cfg.CaptionFolders {
+						caption = g.folders[img.path] + "/" + caption
+					}
+					g.drawCaption(pdf, caption, x, y+height, cellW)
+				} else {
+					g.placeImage(pdf, img, x, y, cellW, cellH)
+				}
+				if !g.failed[img.path] {
+					page.Cells = append(page.Cells, manifestCell{Row: row + 1, Col: col + 1, File: img.path})
+					placed[indices[cell]] = true
+					filledCells++
+				}
+			}
+		}
+		manifest = append(manifest, page)
+
+		if cfg.GridLines != nil {
+			g.drawGridLines(pdf, rows, cols, cellW, cellH)
+		}
+		if cfg.CropMarks {
+			g.drawCropMarks(pdf, pageWidth, pageHeight)
+		}
+		if cfg.Watermark != "" {
+			g.drawWatermark(pdf, cfg.Watermark, pageWidth, pageHeight)
+		}
+		if cfg.PageNumbers {
+			page, total := i+1, cfg.NumPages
+			if cfg.SplitEvery > 0 && cfg.RestartPageNumbers {
+				page = i%cfg.SplitEvery + 1
+				total = min(cfg.SplitEvery, cfg.NumPages-(part-1)*cfg.SplitEvery)
+			}
+			g.drawPageNumber(pdf, page, total, pageWidth, pageHeight)
+		}
+		if cfg.SerialNumbers {
+			g.drawSerialNumber(pdf, cfg.SerialStart+i, pageWidth, pageHeight)
+		}
+		if cfg.PageProgress != nil {
+			cfg.PageProgress(i+1, cfg.NumPages)
+		} else {
+			progressf("\rGenerated page %d/%d", i+1, cfg.NumPages)
+		}
+	}
+
+	if cfg.UniquePages {
+		logInfof("Reshuffled %d times to keep all pages unique", reshuffles)
+	}
+
+	if cfg.PageProgress == nil {
+		progressf("\nGenerated %d pages\n", cfg.NumPages) // Move to a new line after the last update
+	}
+
+	uniqueImages := 0
+	for _, p := range placed {
+		if p {
+			uniqueImages++
+		}
+	}
+	if cfg.Orientations != "" {
+		pageSize += " with mixed orientations"
+	}
+	logInfof("Summary: %d pages of %s, %dx%d grid with %d cells per page, %d cells filled with %d unique images",
+		cfg.NumPages, pageSize, cfg.Rows, cfg.Cols, cellsPerPage, filledCells, uniqueImages)
+
+	if err := writePart(); err != nil {
+		return err
+	}
+
+	if cfg.ManifestPath != "" {
+		if err := writeManifest(cfg.ManifestPath, manifest); err != nil {
+			return err
+		}
+	}
+	if cfg.CallSheetPath != "" {
+		var called []gridImage
+		for i, img := range images {
+			if placed[i] {
+				called = append(called, img)
+			}
+		}
+		return g.writeCallSheet(cfg.CallSheetPath, called)
+	}
+	return nil
+}
+
+// setPDFMetadata fills in the document properties that are set in cfg. Empty fields are
+// skipped, as gofpdf would otherwise write an empty UTF-16 string.
+func setPDFMetadata(pdf *gofpdf.Fpdf, cfg Config) {
+	if cfg.PDFTitle != "" {
+		pdf.SetTitle(cfg.PDFTitle, true)
+	}
+	if cfg.PDFAuthor != "" {
+		pdf.SetAuthor(cfg.PDFAuthor, true)
+	}
+	if cfg.PDFSubject != "" {
+		pdf.SetSubject(cfg.PDFSubject, true)
+	}
+	if cfg.PDFKeywords != "" {
+		pdf.SetKeywords(cfg.PDFKeywords, true)
+	}
+}
+
+// newPDF creates an empty PDF with the page size and orientation of cfg, enlarged by the bleed.
+func newPDF(cfg Config) *gofpdf.Fpdf {
+	orientation := "P"
+	if cfg.Landscape {
+		orientation = "L"
+	}
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{OrientationStr: orientation, UnitStr: "mm", Size: pageFormat(cfg)})
+	pdf.SetAutoPageBreak(false, 0) // Everything is positioned explicitly, text near the bottom must not start a new page
+	// Write the fonts and resources in a fixed order, and with a fixed seed the dates as well,
+	// so the same seed writes the same file. gofpdf only sorts the images by width, so those of
+	// the same width still come out in varying order.
+	pdf.SetCatalogSort(true)
+	if cfg.Seed != nil {
+		pdf.SetCreationDate(reproducibleDate)
+		pdf.SetModificationDate(reproducibleDate)
+	}
+	return pdf
+}
+
+// reproducibleDate is the creation and modification date of PDFs generated with a fixed seed.
+var reproducibleDate = time.Unix(0, 0).UTC()
+
+// pageFormat returns the portrait size of the PDF pages in mm, the page size enlarged by
+// trimInset on all sides.
+func pageFormat(cfg Config) gofpdf.SizeType {
+	size := gofpdf.New("P", "mm", cfg.PageSize, "").GetPageSizeStr(cfg.PageSize)
+	inset := trimInset(cfg)
+	return gofpdf.SizeType{Wd: size.Wd + 2*inset, Ht: size.Ht + 2*inset}
+}
+
+// trimInset returns the distance in mm from the edge of the PDF page to the trim line. It is
+// the bleed, and with crop marks also the room for the marks outside the bleed, where the
+// print shop expects them.
+func trimInset(cfg Config) float64 {
+	if cfg.Bleed == 0 {
+		return 0
+	}
+	if cfg.CropMarks {
+		return cropMarkStart(cfg) + cfg.CropMarkLength
+	}
+	return cfg.Bleed
+}
+
+// cropMarkStart returns the gap in mm between the trimmed area and the crop marks, which
+// keeps them out of the bleed.
+func cropMarkStart(cfg Config) float64 {
+	return math.Max(cfg.CropMarkOffset, cfg.Bleed)
+}
+
+// withMosaic returns cfg without margins, spacing and the decorations that would cover the
+// images or leave room around them (captions, overlays and QR codes) when Mosaic is set.
+func (cfg Config) withMosaic() Config {
+	if !cfg.Mosaic {
+		return cfg
+	}
+	cfg.MarginTop, cfg.MarginBottom, cfg.MarginLeft, cfg.MarginRight = 0, 0, 0, 0
+	cfg.CellSpacing, cfg.CellPadding = 0, 0
+	cfg.Captions = false
+	cfg.Overlay, cfg.OverlayText, cfg.LogoPath = false, "", ""
+	cfg.QR = false
+	return cfg
+}
+
+// withAspectRows returns cfg with as many rows as fit within the margins when the columns fill
+// the page width and the cells keep the CellAspect ratio, instead of the configured Rows. With
+// an orientation pattern the rows fit on every page. A free center cell needs an odd count,
+// so one row less is used when it would be even.
+func (cfg Config) withAspectRows() Config {
+	orientations := strings.ToUpper(cfg.Orientations)
+	if orientations == "" {
+		orientations = "P"
+		if cfg.Landscape {
+			orientations = "L"
+		}
+	}
+	pageWidth, pageHeight := newPDF(cfg).GetPageSize()
+	rows := 0
+	for _, orientation := range orientations {
+		width, height := orientedPageSize(string(orientation), pageWidth, pageHeight)
+		cellWidth := (width - cfg.MarginLeft - cfg.MarginRight - float64(cfg.Cols-1)*cfg.CellSpacing) / float64(cfg.Cols)
+		cellHeight := cellWidth / cfg.CellAspect
+		// Each row but the last is followed by the spacing
+		fit := int((height - cfg.MarginTop - cfg.MarginBottom + cfg.CellSpacing) / (cellHeight + cfg.CellSpacing))
+		if rows == 0 || fit < rows {
+			rows = fit
+		}
+	}
+
+	// Taller cells than the page are shrunk to fit a single row, see gridCellSize
+	cfg.Rows = max(rows, 1)
+	if cfg.FreeCenter && cfg.Rows%2 == 0 {
+		cfg.Rows--
+	}
+	return cfg
+}
+
+// mosaicCellAspect returns the aspect ratio of the cells that divide the area within the
+// margins evenly into the rows and columns, so the grid covers it completely.
+func mosaicCellAspect(cfg Config) float64 {
+	pageWidth, pageHeight := newPDF(cfg).GetPageSize()
+	width := (pageWidth - cfg.MarginLeft - cfg.MarginRight) / float64(cfg.Cols)
+	height := (pageHeight - cfg.MarginTop - cfg.MarginBottom) / float64(cfg.Rows)
+	return width / height
+}
+
+// withCellSize returns cfg with as many rows and columns of CellSize cells as fit within the
+// margins. The space left over is added to the margins on both sides, so the grid is centered
+// and the cells come out at exactly CellSize.
+func (cfg Config) withCellSize() (Config, error) {
+	pageWidth, pageHeight := newPDF(cfg).GetPageSize()
+	areaWidth := pageWidth - cfg.MarginLeft - cfg.MarginRight
+	areaHeight := pageHeight - cfg.MarginTop - cfg.MarginBottom
+	cellHeight := cfg.CellSize / cfg.CellAspect
+
+	// Each cell but the last is followed by the spacing
+	cfg.Cols = int((areaWidth + cfg.CellSpacing) / (cfg.CellSize + cfg.CellSpacing))
+	cfg.Rows = int((areaHeight + cfg.CellSpacing) / (cellHeight + cfg.CellSpacing))
+	if cfg.Rows < 1 || cfg.Cols < 1 {
+		return cfg, fmt.Errorf("a %g mm cell does not fit within the page margins", cfg.CellSize)
+	}
+	if cfg.FreeCenter && (cfg.Rows%2 == 0 || cfg.Cols%2 == 0) {
+		return cfg, fmt.Errorf("a free center cell requires an odd number of rows and columns, %g mm cells fit %dx%d", cfg.CellSize, cfg.Rows, cfg.Cols)
+	}
+
+	extraX := (areaWidth - float64(cfg.Cols)*cfg.CellSize - float64(cfg.Cols-1)*cfg.CellSpacing) / 2
+	extraY := (areaHeight - float64(cfg.Rows)*cellHeight - float64(cfg.Rows-1)*cfg.CellSpacing) / 2
+	cfg.MarginLeft += extraX
+	cfg.MarginRight += extraX
+	cfg.MarginTop += extraY
+	cfg.MarginBottom += extraY
+	return cfg, nil
+}
+
+// withBleed returns cfg with the margins measured from the edge of the PDF page instead
+// of the trim line. A margin of 0 runs the grid out to the edge of the bleed, so the
+// images of the edge cells still reach the edge of the page after trimming.
+func (cfg Config) withBleed() Config {
+	if cfg.Bleed == 0 {
+		return cfg
+	}
+	inset := trimInset(cfg)
+	for _, margin := range []*float64{&cfg.MarginTop, &cfg.MarginBottom, &cfg.MarginLeft, &cfg.MarginRight} {
+		if *margin == 0 {
+			*margin = inset - cfg.Bleed
+		} else {
+			*margin += inset
+		}
+	}
+	return cfg
+}
+
+// layout computes the grid size, cell size and number of pages for numImages images on
+// pages of the given size (in mm).
+func (g *Generator) layout(numImages int, pageWidth, pageHeight float64) (Plan, error) {
+	cfg := g.cfg
+	plan := Plan{Images: numImages, Rows: cfg.Rows, Cols: cfg.Cols, Pages: cfg.NumPages}
+
+	if cfg.AutoGrid {
+		plan.Rows, plan.Cols = autoGrid(numImages)
+		logInfof("Using a %dx%d grid for %d images", plan.Rows, plan.Cols, numImages)
+	}
+
+	plan.CellSize, _ = g.cellSizeRange(plan.Rows, plan.Cols, pageWidth, pageHeight)
+	plan.CellHeight = plan.CellSize / cfg.CellAspect
+	if plan.CellSize <= 0 {
+		return Plan{}, fmt.Errorf("a %dx%d grid with %g mm spacing does not fit within the page margins", plan.Rows, plan.Cols, cfg.CellSpacing)
+	}
+	imageWidth, imageHeight := plan.CellSize, plan.CellHeight
+	if cfg.Captions {
+		if plan.CellHeight <= captionHeight {
+			return Plan{}, fmt.Errorf("the %.1f mm high cells of a %dx%d grid leave no room for the images above the %g mm captions", plan.CellHeight, plan.Rows, plan.Cols, captionHeight)
+		}
+		// The images shrink to make room for the caption below them, see generatePDFParts
+		imageHeight -= captionHeight
+		imageWidth = imageHeight * cfg.CellAspect
+	}
+	if 2*cfg.CellPadding >= math.Min(imageWidth, imageHeight) {
+		return Plan{}, fmt.Errorf("a cell padding of %g mm leaves no room for the %.1fx%.1f mm images", cfg.CellPadding, imageWidth, imageHeight)
+	}
+
+	plan.CellsPerPage = imageCells(cfg, plan.Rows, plan.Cols)
+	if cfg.AutoGrid {
+		// Every image is placed once per page, the cells after the last image stay blank
+		plan.CellsPerPage = numImages
+	}
+	if cfg.ContactSheet {
+		// Every image is placed once, so the number of pages follows from the image count
+		plan.Pages = (numImages + plan.CellsPerPage - 1) / plan.CellsPerPage
+	} else if !cfg.AutoGrid && numImages < plan.CellsPerPage {
+		logWarnf("Only %d images for %d cells per page, some images will repeat within a page", numImages, plan.CellsPerPage)
+	}
+
+	if cfg.UniquePages && maxUniqueLayouts(numImages, plan.CellsPerPage, plan.Pages) < plan.Pages {
+		return Plan{}, fmt.Errorf("cannot generate %d unique pages from %d images with %d cells per page", plan.Pages, numImages, plan.CellsPerPage)
+	}
+	return plan, nil
+}
+
+// imageCells returns the number of cells of a rows x cols grid that hold an image, all but
+// the free center cell.
+func imageCells(cfg Config, rows, cols int) int {
+	if cfg.FreeCenter {
+		return rows*cols - 1
+	}
+	return rows * cols
+}
+
+// cellSizeRange returns the smallest and largest cell width (in mm) of a rows x cols grid
+// across the page orientations used, given the size of a page in either orientation.
+func (g *Generator) cellSizeRange(rows, cols int, pageWidth, pageHeight float64) (smallest, largest float64) {
+	if g.cfg.Orientations == "" {
+		size := gridCellSize(g.cfg, rows, cols, pageWidth, pageHeight)
+		return size, size
+	}
+	smallest = math.Inf(1)
+	for i := range g.cfg.Orientations {
+		width, height := orientedPageSize(g.pageOrientation(i), pageWidth, pageHeight)
+		size := gridCellSize(g.cfg, rows, cols, width, height)
+		smallest, largest = math.Min(smallest, size), math.Max(largest, size)
+	}
+	return smallest, largest
+}
+
+// pageOrientation returns the orientation ("P" or "L") of the grid page with the 0-based
+// index page, following the Orientations pattern.
+func (g *Generator) pageOrientation(page int) string {
+	if g.cfg.Orientations == "" {
+		if g.cfg.Landscape {
+			return "L"
+		}
+		return "P"
+	}
+	return strings.ToUpper(string(g.cfg.Orientations[page%len(g.cfg.Orientations)]))
+}
+
+// orientedPageSize returns the width and height of a page turned to the orientation.
+func orientedPageSize(orientation string, width, height float64) (float64, float64) {
+	if (orientation == "L") != (width > height) {
+		return height, width
+	}
+	return width, height
+}
+
+// largestCellGrid returns the rows and columns of the grid with the largest cells that
+// holds n images on pages of the given size, and the width of its cells.
+func largestCellGrid(cfg Config, n int, pageWidth, pageHeight float64) (rows, cols int, cellWidth float64) {
+	for c := 1; c <= n; c++ {
+		r := (n + c - 1) / c
+		if width := gridCellSize(cfg, r, c, pageWidth, pageHeight); width > cellWidth {
+			rows, cols, cellWidth = r, c, width
+		}
+	}
+	return rows, cols, cellWidth
+}
+
+// gridCellSize returns the width (in mm) of the cells of a rows x cols grid on pages of the
+// given size. The cells keep the CellAspect ratio, so their height is the width divided by it.
+func gridCellSize(cfg Config, rows, cols int, pageWidth, pageHeight float64) float64 {
+	cellSize := (pageWidth - cfg.MarginLeft - cfg.MarginRight - float64(cols-1)*cfg.CellSpacing) / float64(cols)
+
+	// In landscape (or with many rows) the width-based size can overflow the page height
+	maxCellHeight := (pageHeight - cfg.MarginTop - cfg.MarginBottom - float64(rows-1)*cfg.CellSpacing) / float64(rows)
+	if maxCellHeight*cfg.CellAspect < cellSize {
+		cellSize = maxCellHeight * cfg.CellAspect
+	}
+	return cellSize
+}
+
+// drawCoverPage adds a page with the title, subtitle and generation date centered on it.
+// The cover page is not counted in the page numbers.
+func drawCoverPage(pdf *gofpdf.Fpdf, title, subtitle string, pageWidth, pageHeight float64) {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+	pdf.SetTextColor(0, 0, 0)
+
+	y := pageHeight/2 - 20
+	pdf.SetFont("Helvetica", "B", 32)
+	pdf.SetXY(0, y)
+	pdf.CellFormat(pageWidth, 16, tr(title), "", 0, "CM", false, 0, "")
+
+	if subtitle != "" {
+		y += 16
+		pdf.SetFont("Helvetica", "", 18)
+		pdf.SetXY(0, y)
+		pdf.CellFormat(pageWidth, 10, tr(subtitle), "", 0, "CM", false, 0, "")
+	}
+
+	y += 16
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.SetXY(0, y)
+	pdf.CellFormat(pageWidth, 8, time.Now().Format("2 January 2006"), "", 0, "CM", false, 0, "")
+}
+
+// drawFreeCell draws the label centered in the free cell at x, y.
+func drawFreeCell(pdf *gofpdf.Fpdf, label string, x, y, w, h float64) {
+	if label == "" {
+		return
+	}
+	pdf.SetFont("Helvetica", "B", math.Min(w, h)*0.25*72/25.4) // Font size is in points, cell size in mm
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetXY(x, y)
+	pdf.CellFormat(w, h, label, "", 0, "CM", false, 0, "")
+}
+
+// customFont is the family name FontPath is registered under in the PDFs.
+const customFont = "custom"
+
+// addFont registers the FontPath font with pdf, if there is one.
+func (g *Generator) addFont(pdf *gofpdf.Fpdf) {
+	if g.fontData != nil {
+		pdf.AddUTF8FontFromBytes(customFont, "", g.fontData)
+	}
+}
+
+// setNameFont selects the font of image names at size points and returns the translator
+// for the text. The custom font takes UTF-8, so any script in the file names renders, while
+// the built-in Helvetica only covers cp1252.
+func (g *Generator) setNameFont(pdf *gofpdf.Fpdf, size float64) func(string) string {
+	if g.fontData != nil {
+		pdf.SetFont(customFont, "", size)
+		return func(s string) string { return s }
+	}
+	pdf.SetFont("Helvetica", "", size)
+	return pdf.UnicodeTranslatorFromDescriptor("")
+}
+
+// drawCaption draws the caption centered below an image, truncating it with an ellipsis
+// when it is wider than the cell.
+func (g *Generator) drawCaption(pdf *gofpdf.Fpdf, caption string, x, y, width float64) {
+	tr := g.setNameFont(pdf, 7)
+	pdf.SetTextColor(0, 0, 0)
+
+	pdf.SetXY(x, y)
+	pdf.CellFormat(width, captionHeight, fitText(pdf, tr, caption, width), "", 0, "CM", false, 0, "")
+}
+
+// fitText translates text for the current font and shortens it with an ellipsis until it
+// is at most width wide.
+func fitText(pdf *gofpdf.Fpdf, tr func(string) string, text string, width float64) string {
+	if pdf.GetStringWidth(tr(text)) <= width {
+		return tr(text)
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(tr(string(runes)+"…")) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return tr(string(runes) + "…")
+}
+
+// drawWatermark draws the text rotated and semi-transparent across the center of the page,
+// on top of the images.
+func (g *Generator) drawWatermark(pdf *gofpdf.Fpdf, text string, pageWidth, pageHeight float64) {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	lineHeight := g.cfg.WatermarkSize * 25.4 / 72 // Font size is in points, page in mm
+
+	pdf.SetFont("Helvetica", "B", g.cfg.WatermarkSize)
+	pdf.SetTextColor(150, 150, 150)
+	pdf.SetAlpha(0.25, "Normal")
+	pdf.TransformBegin()
+	pdf.TransformRotate(g.cfg.WatermarkAngle, pageWidth/2, pageHeight/2)
+	pdf.SetXY(0, (pageHeight-lineHeight)/2)
+	pdf.CellFormat(pageWidth, lineHeight, tr(text), "", 0, "CM", false, 0, "")
+	pdf.TransformEnd()
+	pdf.SetAlpha(1, "Normal")
+}
+
+// drawPageBackground fills the page with the background color and covers it with the
+// background image, cropping the image to the page shape around its center.
+func (g *Generator) drawPageBackground(pdf *gofpdf.Fpdf, pageWidth, pageHeight float64) error {
+	if g.cfg.PageColor != nil {
+		r, gr, b, _ := g.cfg.PageColor.RGBA()
+		pdf.SetFillColor(int(r>>8), int(gr>>8), int(b>>8))
+		pdf.Rect(0, 0, pageWidth, pageHeight, "F")
+	}
+	if g.cfg.PageImage == "" {
+		return nil
+	}
+
+	// gofpdf reads the file once per document and reuses it on the later pages
+	info := pdf.RegisterImageOptions(g.cfg.PageImage, gofpdf.ImageOptions{ReadDpi: false})
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("failed to load page background image: %w", err)
+	}
+	scale := math.Max(pageWidth/info.Width(), pageHeight/info.Height())
+	w, h := info.Width()*scale, info.Height()*scale
+	pdf.ClipRect(0, 0, pageWidth, pageHeight, false)
+	pdf.ImageOptions(g.cfg.PageImage, (pageWidth-w)/2, (pageHeight-h)/2, w, h, false, gofpdf.ImageOptions{AllowNegativePosition: true}, 0, "")
+	pdf.ClipEnd()
+	return nil
+}
+
+// drawGridLines draws continuous lines around and between the cells of a rows x cols grid,
+// centered in the spacing between the cells.
+func (g *Generator) drawGridLines(pdf *gofpdf.Fpdf, rows, cols int, cellWidth, cellHeight float64) {
+	stepX, stepY := cellWidth+g.cfg.CellSpacing, cellHeight+g.cfg.CellSpacing
+	left := g.cfg.MarginLeft - g.cfg.CellSpacing/2
+	top := g.cfg.MarginTop - g.cfg.CellSpacing/2
+	right := left + float64(cols)*stepX
+	bottom := top + float64(rows)*stepY
+
+	r, gr, b, _ := g.cfg.GridLines.RGBA()
+	pdf.SetDrawColor(int(r>>8), int(gr>>8), int(b>>8))
+	pdf.SetLineWidth(g.cfg.GridLineWidth)
+	for row := 0; row <= rows; row++ {
+		y := top + float64(row)*stepY
+		pdf.Line(left, y, right, y)
+	}
+	for col := 0; col <= cols; col++ {
+		x := left + float64(col)*stepX
+		pdf.Line(x, top, x, bottom)
+	}
+}
+
+// drawCropMarks draws short horizontal and vertical lines in the margins at the four
+// corners of the printable area, to guide trimming. With a bleed they mark the trim line
+// instead, outside the bleed.
+func (g *Generator) drawCropMarks(pdf *gofpdf.Fpdf, pageWidth, pageHeight float64) {
+	left, right := g.cfg.MarginLeft, pageWidth-g.cfg.MarginRight
+	top, bottom := g.cfg.MarginTop, pageHeight-g.cfg.MarginBottom
+	offset, length := g.cfg.CropMarkOffset, g.cfg.CropMarkLength
+	if g.cfg.Bleed > 0 {
+		inset := trimInset(g.cfg)
+		left, right, top, bottom = inset, pageWidth-inset, inset, pageHeight-inset
+		offset = cropMarkStart(g.cfg)
+	}
+
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetLineWidth(0.2)
+	for _, x := range []float64{left, right} {
+		for _, y := range []float64{top, bottom} {
+			// Point the marks away from the printable area
+			dx, dy := -1.0, -1.0
+			if x == right {
+				dx = 1
+			}
+			if y == bottom {
+				dy = 1
+			}
+			pdf.Line(x+dx*offset, y, x+dx*(offset+length), y)
+			pdf.Line(x, y+dy*offset, x, y+dy*(offset+length))
+		}
+	}
+}
+
+// drawSerialNumber stamps the zero-padded serial number in the bottom-right corner of the page
+// margin, or the bottom-left one when the page number is printed on the right.
+func (g *Generator) drawSerialNumber(pdf *gofpdf.Fpdf, serial int, pageWidth, pageHeight float64) {
+	align := "RM"
+	if g.cfg.PageNumbers && g.cfg.PageNumberAlign == "right" {
+		align = "LM"
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetXY(g.cfg.MarginLeft, pageHeight-g.cfg.MarginBottom)
+	pdf.CellFormat(pageWidth-g.cfg.MarginLeft-g.cfg.MarginRight, g.cfg.MarginBottom, fmt.Sprintf("No. %0*d", g.cfg.SerialWidth, serial), "", 0, align, false, 0, "")
+}
+
+// autoGrid returns the most square grid that holds n images, with at least as many
+// columns as rows.
+func autoGrid(n int) (rows, cols int) {
+	cols = int(math.Ceil(math.Sqrt(float64(n))))
+	rows = (n + cols - 1) / cols
+	return rows, cols
+}
+
+// drawPageNumber prints "Page X of N" in the bottom margin of the page.
+func (g *Generator) drawPageNumber(pdf *gofpdf.Fpdf, page, total int, pageWidth, pageHeight float64) {
+	align := map[string]string{"left": "LM", "center": "CM", "right": "RM"}[g.cfg.PageNumberAlign]
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetXY(g.cfg.MarginLeft, pageHeight-g.cfg.MarginBottom)
+	pdf.CellFormat(pageWidth-g.cfg.MarginLeft-g.cfg.MarginRight, g.cfg.MarginBottom, fmt.Sprintf("Page %d of %d", page, total), "", 0, align, false, 0, "")
+}
+
+// pageImageIndices returns the image index for every cell of the given page. Images are
+// tiled across pages in order, taking a contiguous window of cellsPerPage indices, so no
+// image repeats within a page as long as there are at least cellsPerPage images.
+func pageImageIndices(page, cellsPerPage, numImages int) []int {
+	indices := make([]int, cellsPerPage)
+	for cell := range indices {
+		indices[cell] = (page*cellsPerPage + cell) % numImages
+	}
+	return indices
+}
+
+// weightedShuffle reorders the image indices in order so that images of higher weight tend
+// to come first, drawing each position in proportion to the weights of the images left.
+// Every image gets an exponentially distributed key with rate equal to its weight and
+// the indices are sorted by key, which is equivalent to drawing without replacement.
+func (g *Generator) weightedShuffle(order []int, images []gridImage) {
+	keys := make([]float64, len(images))
+	for i, img := range images {
+		keys[i] = g.rng.ExpFloat64() / img.weight
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return keys[order[a]] < keys[order[b]]
+	})
+}
+
+// maxUniqueLayouts returns the number of distinct image arrangements a page can have,
+// capped at limit. With fewer images than cells, the arrangement is fully determined by
+// the order of all images.
+func maxUniqueLayouts(numImages, cellsPerPage, limit int) int {
+	picks := cellsPerPage
+	if numImages < picks {
+		picks = numImages
+	}
+	layouts := 1
+	for k := 0; k < picks && layouts < limit; k++ {
+		layouts *= numImages - k
+	}
+	return layouts
+}
+
+// placeImage adds the image to the w x h cell at x, y. With RandomRotate the image is
+// turned by a random angle and shrunk so its corners stay within the cell.
+func (g *Generator) placeImage(pdf *gofpdf.Fpdf, img gridImage, x, y, w, h float64) {
+	if !g.cfg.RandomRotate {
+		g.drawShadow(pdf, x, y, w, h)
+		g.addImageToPDF(pdf, img, x, y, w, h)
+		return
+	}
+
+	angle := (g.rng.Float64()*2 - 1) * g.cfg.RandomRotateMax
+	rad := angle * math.Pi / 180
+	cos, sin := math.Abs(math.Cos(rad)), math.Abs(math.Sin(rad))
+	scale := math.Min(w/(w*cos+h*sin), h/(w*sin+h*cos)) // Largest scale whose rotated bounding box fits the cell
+	sw, sh := w*scale, h*scale
+	offsetX, offsetY := (w-sw)/2, (h-sh)/2
+
+	pdf.TransformBegin()
+	pdf.TransformRotate(angle, x+w/2, y+h/2)
+	g.drawShadow(pdf, x+offsetX, y+offsetY, sw, sh)
+	g.addImageToPDF(pdf, img, x+offsetX, y+offsetY, sw, sh)
+	pdf.TransformEnd()
+}
+
+// drawShadow draws the drop shadow of an image at x, y. It has to be drawn before the
+// image, so the image covers all but the offset edges.
+func (g *Generator) drawShadow(pdf *gofpdf.Fpdf, x, y, w, h float64) {
+	if g.cfg.Shadow == nil {
+		return
+	}
+	x, y, w, h = g.padCell(x, y, w, h)
+	r, gr, b, _ := g.cfg.Shadow.RGBA()
+	pdf.SetFillColor(int(r>>8), int(gr>>8), int(b>>8))
+	pdf.Rect(x+g.cfg.ShadowOffset, y+g.cfg.ShadowOffset, w, h, "F")
+}
+
+// padCell returns the area of the w x h cell at x, y that its image covers with CellPadding.
+// The image is shrunk evenly, so it keeps the aspect ratio of the cell and the padding is
+// only wider than CellPadding on the longer sides.
+func (g *Generator) padCell(x, y, w, h float64) (float64, float64, float64, float64) {
+	if g.cfg.CellPadding <= 0 {
+		return x, y, w, h
+	}
+	scale := math.Min((w-2*g.cfg.CellPadding)/w, (h-2*g.cfg.CellPadding)/h)
+	pw, ph := w*scale, h*scale
+	return x + (w-pw)/2, y + (h-ph)/2, pw, ph
+}
+
+func (g *Generator) addImageToPDF(pdf *gofpdf.Fpdf, img gridImage, x, y, w, h float64) {
+	imageName, ok := g.registerImage(pdf, img)
+	if !ok {
+		return // Leave the cell blank
+	}
+	ix, iy, iw, ih := g.padCell(x, y, w, h)
+	pdf.ImageOptions(imageName, ix, iy, iw, ih, false, gofpdf.ImageOptions{ImageType: g.cellImageType(), ReadDpi: true}, 0, "")
+
+	if g.cfg.CellBorder != nil {
+		// Inset the rectangle by half the line width, so the border stays within the cell
+		// and never reaches into the spacing or a neighboring cell. With CellPadding it
+		// frames the padding as a mat around the image.
+		r, gr, b, _ := g.cfg.CellBorder.RGBA()
+		inset := g.cfg.CellBorderWidth / 2
+		pdf.SetDrawColor(int(r>>8), int(gr>>8), int(b>>8))
+		pdf.SetLineWidth(g.cfg.CellBorderWidth)
+		pdf.Rect(x+inset, y+inset, w-2*inset, h-2*inset, "D")
+	}
+}
+
+// registerImage adds the image to the PDF unless it is already there and returns the name
+// it is registered under. It reports false for streamed images that fail to load.
+func (g *Generator) registerImage(pdf *gofpdf.Fpdf, img gridImage) (string, bool) {
+	imageName := g.imageName(img)
+	if pdf.GetImageInfo(imageName) == nil {
+		imgData := img.data
+		if g.cfg.Stream {
+			// Resize on first use, the PDF keeps its own copy for repeated placements
+			if g.failed[img.path] {
+				return "", false
+			}
+			var err error
+			if imgData, err = g.resizeImageCached(img.path, img.modTime, img.size); err != nil {
+				logWarnf("Failed to process image %s: %v", img.path, err)
+				g.failed[img.path] = true
+				return "", false
+			}
+		}
+		pdf.RegisterImageOptionsReader(imageName, gofpdf.ImageOptions{ImageType: g.cellImageType(), ReadDpi: true}, bytes.NewReader(imgData))
+	}
+	return imageName, true
+}
+
+// imageName returns the name the image is registered under in the PDF.
+func (g *Generator) imageName(img gridImage) string {
+	if g.cfg.Stream {
+		// The content is not known before resizing, so name streamed images after their file
+		return fmt.Sprintf("src_%x", sha1.Sum([]byte(img.path)))
+	}
+	return fmt.Sprintf("img_%x", sha1.Sum(img.data)) // Generate a consistent name for the image based on its content
+}
+
+// registerStreamed resizes the streamed images that are not in the PDF yet, up to Workers
+// at a time, and registers them in the order of imgs. The PDF comes out the same as when
+// registerImage resizes them one by one as they are placed, only faster.
+func (g *Generator) registerStreamed(pdf *gofpdf.Fpdf, imgs []gridImage) {
+	type resized struct {
+		img  gridImage
+		name string
+		data []byte
+		err  error
+	}
+	var pending []*resized
+	queued := make(map[string]bool)
+	for _, img := range imgs {
+		name := g.imageName(img)
+		if queued[name] || g.failed[img.path] || pdf.GetImageInfo(name) != nil {
+			continue
+		}
+		queued[name] = true
+		pending = append(pending, &resized{img: img, name: name})
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, g.cfg.Workers)
+	for _, r := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *resized) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r.data, r.err = g.resizeImageCached(r.img.path, r.img.modTime, r.img.size)
+		}(r)
+	}
+	wg.Wait()
+
+	// gofpdf is not safe for concurrent use, so the images are added once all are resized
+	for _, r := range pending {
+		if r.err != nil {
+			logWarnf("Failed to process image %s: %v", r.img.path, r.err)
+			g.failed[r.img.path] = true
+			continue
+		}
+		pdf.RegisterImageOptionsReader(r.name, gofpdf.ImageOptions{ImageType: g.cellImageType(), ReadDpi: true}, bytes.NewReader(r.data))
+	}
+}
@@ -0,0 +1,312 @@
+package gridpdf
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	SetLogOutput(io.Discard) // Layouts warn about repeated images
+	os.Exit(m.Run())
+}
+
+func TestMaxUniqueLayouts(t *testing.T) {
+	tests := []struct {
+		name                           string
+		numImages, cellsPerPage, limit int
+		want                           int
+	}{
+		{"ordered picks", 5, 3, 1000, 60},
+		{"one cell", 7, 1, 1000, 7},
+		{"stops at the limit", 5, 3, 10, 20},
+		{"limit never overflows", 1000, 100, 50, 1000},
+		// With fewer images than cells every image is on the page, so only their order counts
+		{"fewer images than cells", 3, 5, 1000, 6},
+		{"single image", 1, 4, 1000, 1},
+	}
+	for _, tt := range tests {
+		if got := maxUniqueLayouts(tt.numImages, tt.cellsPerPage, tt.limit); got != tt.want {
+			t.Errorf("%s: maxUniqueLayouts(%d, %d, %d) = %d, want %d", tt.name, tt.numImages, tt.cellsPerPage, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestLayoutUniquePages(t *testing.T) {
+	tests := []struct {
+		numImages, pages int
+		wantErr          bool
+	}{
+		{4, 24, false}, // 4! arrangements of a full page
+		{4, 25, true},
+		{3, 6, false}, // 3 images on 4 cells repeat one, leaving 3! orders
+		{3, 7, true},
+		{1, 2, true},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Rows, cfg.Cols = 2, 2
+		cfg.NumPages = tt.pages
+		cfg.UniquePages = true
+		g := Generator{cfg: cfg}
+		_, err := g.layout(tt.numImages, 210, 297)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d images on %d pages: got error %v, want error %v", tt.numImages, tt.pages, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPageImageIndices(t *testing.T) {
+	tests := []struct {
+		page, cellsPerPage, numImages int
+		want                          []int
+	}{
+		{0, 3, 5, []int{0, 1, 2}},
+		{1, 3, 5, []int{3, 4, 0}}, // Wraps around to the first images
+		{2, 3, 5, []int{1, 2, 3}},
+		{0, 4, 2, []int{0, 1, 0, 1}},
+	}
+	for _, tt := range tests {
+		got := pageImageIndices(tt.page, tt.cellsPerPage, tt.numImages)
+		if !equalInts(got, tt.want) {
+			t.Errorf("pageImageIndices(%d, %d, %d) = %v, want %v", tt.page, tt.cellsPerPage, tt.numImages, got, tt.want)
+		}
+	}
+}
+
+func TestLayoutCaptionsTooShort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Rows, cfg.Cols = 60, 60
+	cfg.NumPages = 1
+	cfg.Captions = true
+	g := Generator{cfg: cfg}
+	if _, err := g.layout(10, 210, 297); err == nil || !strings.Contains(err.Error(), "captions") {
+		t.Errorf("got error %v, want the captions not to fit", err)
+	}
+}
+
+func TestAutoGrid(t *testing.T) {
+	tests := []struct{ n, rows, cols int }{
+		{1, 1, 1},
+		{4, 2, 2},
+		{5, 2, 3},
+		{10, 3, 4},
+		{16, 4, 4},
+	}
+	for _, tt := range tests {
+		if rows, cols := autoGrid(tt.n); rows != tt.rows || cols != tt.cols {
+			t.Errorf("autoGrid(%d) = %dx%d, want %dx%d", tt.n, rows, cols, tt.rows, tt.cols)
+		}
+	}
+}
+
+func TestSplitFileName(t *testing.T) {
+	tests := []struct {
+		output string
+		part   int
+		want   string
+	}{
+		{"output.pdf", 1, "output_001.pdf"},
+		{"dir/sheets.pdf", 12, "dir/sheets_012.pdf"},
+		{"output", 3, "output_003"},
+		{"many.pdf", 1234, "many_1234.pdf"},
+	}
+	for _, tt := range tests {
+		if got := SplitFileName(tt.output, tt.part); got != tt.want {
+			t.Errorf("SplitFileName(%q, %d) = %q, want %q", tt.output, tt.part, got, tt.want)
+		}
+	}
+}
+
+func TestWeightedShuffle(t *testing.T) {
+	g := Generator{rng: rand.New(rand.NewSource(1))}
+	images := []gridImage{{weight: 9}, {weight: 1}}
+	const trials = 5000
+	first := 0
+	for i := 0; i < trials; i++ {
+		order := []int{0, 1}
+		g.weightedShuffle(order, images)
+		if order[0] == 0 {
+			first++
+		}
+	}
+	// The heavier image comes first in proportion to its share of the weight, 90%
+	if share := float64(first) / trials; math.Abs(share-0.9) > 0.02 {
+		t.Errorf("the image of weight 9 came first in %.1f%% of the shuffles, want about 90%%", share*100)
+	}
+}
+
+func TestWithAspectRows(t *testing.T) {
+	// 4 columns within 10 mm margins on A4 are 47.5 mm wide without spacing
+	tests := []struct {
+		name         string
+		aspect       float64
+		spacing      float64
+		orientations string
+		freeCenter   bool
+		want         int
+	}{
+		{"square", 1, 0, "", false, 5},
+		{"portrait cells", 0.75, 0, "", false, 4},
+		{"landscape cells", 16.0 / 9, 0, "", false, 10},
+		{"free center needs an odd count", 16.0 / 9, 0, "", true, 9},
+		{"wide cells", 2, 0, "", false, 11},
+		{"spacing", 2, 5, "", false, 10}, // 43.75 mm wide cells and 5 mm between the rows
+		// Landscape pages fit 2 rows of the 69.25 mm cells, so every page gets 2
+		{"orientation pattern", 1, 0, "PL", false, 2},
+		{"taller than the page", 0.1, 0, "", false, 1},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Cols = 4
+		cfg.CellAspect = tt.aspect
+		cfg.CellSpacing = tt.spacing
+		cfg.Orientations = tt.orientations
+		cfg.FreeCenter = tt.freeCenter
+		if got := cfg.withAspectRows(); got.Rows != tt.want || got.Cols != 4 {
+			t.Errorf("%s: got %dx%d, want %dx4", tt.name, got.Rows, got.Cols, tt.want)
+		}
+	}
+}
+
+func TestFormatFileSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{999, "999 B"},
+		{1000, "1.0 kB"},
+		{2500000, "2.5 MB"},
+		{3e12, "3000.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatFileSize(tt.size); got != tt.want {
+			t.Errorf("formatFileSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestWithBleed(t *testing.T) {
+	tests := []struct {
+		name             string
+		bleed, margin    float64
+		cropMarks        bool
+		wantInset        float64
+		wantMargin       float64
+		wantZeroedMargin float64 // a margin of 0 runs the grid into the bleed
+	}{
+		{"no bleed", 0, 10, false, 0, 10, 0},
+		{"bleed", 3, 10, false, 3, 13, 0},
+		// The crop marks start after the bleed (the 3 mm offset set below is covered by it)
+		// and take their length on top
+		{"bleed with crop marks", 5, 10, true, 10, 20, 5},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Bleed, cfg.CropMarks = tt.bleed, tt.cropMarks
+		cfg.CropMarkOffset, cfg.CropMarkLength = 3, 5
+		cfg.MarginTop, cfg.MarginLeft = tt.margin, 0
+		if got := trimInset(cfg); got != tt.wantInset {
+			t.Errorf("%s: trimInset = %g, want %g", tt.name, got, tt.wantInset)
+		}
+		bled := cfg.withBleed()
+		if bled.MarginTop != tt.wantMargin || bled.MarginLeft != tt.wantZeroedMargin {
+			t.Errorf("%s: margins top %g, left %g, want %g, %g", tt.name, bled.MarginTop, bled.MarginLeft, tt.wantMargin, tt.wantZeroedMargin)
+		}
+		format, trim := pageFormat(cfg), pageFormat(DefaultConfig())
+		if !near(format.Wd, trim.Wd+2*tt.wantInset) || !near(format.Ht, trim.Ht+2*tt.wantInset) {
+			t.Errorf("%s: page is %gx%g mm, want A4 plus %g mm on each side", tt.name, format.Wd, format.Ht, tt.wantInset)
+		}
+	}
+}
+
+func TestWithCellSize(t *testing.T) {
+	// 190 x 277 mm fit within the 10 mm margins on A4
+	tests := []struct {
+		name                  string
+		size, aspect, spacing float64
+		freeCenter            bool
+		rows, cols            int
+		marginLeft, marginTop float64
+	}{
+		{"square", 40, 1, 0, false, 6, 4, 25, 28.5},
+		{"spacing", 40, 1, 5, false, 6, 4, 17.5, 16},
+		{"tall cells", 40, 0.5, 0, false, 3, 4, 25, 28.5},
+		{"free center", 50, 1, 0, true, 5, 3, 30, 23.5},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.CellSize, cfg.CellAspect, cfg.CellSpacing = tt.size, tt.aspect, tt.spacing
+		cfg.FreeCenter = tt.freeCenter
+		got, err := cfg.withCellSize()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Rows != tt.rows || got.Cols != tt.cols {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, got.Rows, got.Cols, tt.rows, tt.cols)
+		}
+		// The page is a fraction of a mm larger than 210 x 297 mm
+		if math.Abs(got.MarginLeft-tt.marginLeft) > 0.01 || math.Abs(got.MarginTop-tt.marginTop) > 0.01 ||
+			got.MarginLeft != got.MarginRight || got.MarginTop != got.MarginBottom {
+			t.Errorf("%s: margins left %g, right %g, top %g, bottom %g, want %g and %g on both sides",
+				tt.name, got.MarginLeft, got.MarginRight, got.MarginTop, got.MarginBottom, tt.marginLeft, tt.marginTop)
+		}
+	}
+
+	for _, tt := range []struct {
+		name       string
+		size       float64
+		freeCenter bool
+	}{
+		{"larger than the page", 300, false},
+		{"even grid with a free center", 40, true},
+	} {
+		cfg := DefaultConfig()
+		cfg.CellSize, cfg.CellSpacing = tt.size, 0
+		cfg.FreeCenter = tt.freeCenter
+		if _, err := cfg.withCellSize(); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
+
+func TestPadCell(t *testing.T) {
+	tests := []struct {
+		name           string
+		padding        float64
+		x, y, w, h     float64
+		wx, wy, ww, wh float64
+	}{
+		{"no padding", 0, 10, 20, 40, 30, 10, 20, 40, 30},
+		{"square", 2, 0, 0, 20, 20, 2, 2, 16, 16},
+		// The image keeps the 4:3 shape, so the padding is only exact on the short sides
+		{"landscape", 3, 0, 0, 40, 30, 4, 3, 32, 24},
+	}
+	for _, tt := range tests {
+		g := Generator{cfg: Config{CellPadding: tt.padding}}
+		x, y, w, h := g.padCell(tt.x, tt.y, tt.w, tt.h)
+		if !near(x, tt.wx) || !near(y, tt.wy) || !near(w, tt.ww) || !near(h, tt.wh) {
+			t.Errorf("%s: got %g,%g %gx%g, want %g,%g %gx%g", tt.name, x, y, w, h, tt.wx, tt.wy, tt.ww, tt.wh)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
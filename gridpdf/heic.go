@@ -0,0 +1,6 @@
+//go:build cgo
+
+package gridpdf
+
+// The HEIC decoder compiles a bundled copy of libde265, so it is only available in CGO builds.
+import _ "github.com/jdeng/goheif" // Register the HEIC/HEIF decoder
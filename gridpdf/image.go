@@ -0,0 +1,704 @@
+package gridpdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/skip2/go-qrcode"
+	_ "golang.org/x/image/bmp" // Register the BMP decoder, .bmp files were accepted but could not be decoded
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/tiff" // Register the TIFF decoder
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+)
+
+// interpolations maps the names accepted by Config.Interp to resize interpolation functions.
+var interpolations = map[string]resize.InterpolationFunction{
+	"nearest":  resize.NearestNeighbor,
+	"bilinear": resize.Bilinear,
+	"bicubic":  resize.Bicubic,
+	"lanczos2": resize.Lanczos2,
+	"lanczos3": resize.Lanczos3,
+}
+
+// retryDelay is the wait before the first retry of a failed image, it doubles with every
+// further attempt.
+const retryDelay = 100 * time.Millisecond
+
+// resizeImage opens, decodes and resizes the image at imagePath, retrying up to Retries
+// times when that fails for reasons other than a missing file or an unknown format.
+func (g *Generator) resizeImage(imagePath string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := g.resizeImageFile(imagePath)
+		if err == nil || attempt >= g.cfg.Retries || errors.Is(err, image.ErrFormat) || errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+		delay := retryDelay << attempt
+		logInfof("Failed to process image %s, retrying in %v: %v", imagePath, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+func (g *Generator) resizeImageFile(imagePath string) ([]byte, error) {
+	file, err := g.openImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return g.resizeImageReader(file, imagePath)
+}
+
+// decodeImage decodes the image in data. JPEGs at least twice the target size are
+// downscaled while decoding where supported, which saves most of the memory and time of
+// decoding large photos only to shrink them to the cell size.
+func (g *Generator) decodeImage(data []byte) (image.Image, string, error) {
+	size := int(max(g.imageWidth, g.imageHeight))
+	if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && format == "jpeg" && min(config.Width, config.Height) >= 2*size {
+		img, err := decodeJPEGScaled(data, size)
+		if err != nil {
+			return nil, "", err
+		}
+		if img != nil {
+			return img, format, nil
+		}
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// resizeImageReader decodes an image from r and runs it through the resize, overlay and
+// encode pipeline, encoding it in the cell format. The name identifies the image in log messages.
+func (g *Generator) resizeImageReader(r io.Reader, name string) ([]byte, error) {
+	// The data is read twice, once for the EXIF tags and once for the pixels
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, format, err := g.decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "tiff" && isMultiPageTIFF(bytes.NewReader(data)) {
+		logInfof("%s is a multi-page TIFF, only the first page is used", name)
+	}
+
+	if format == "gif" {
+		var frames int
+		img, frames, err = decodeGIFFirstFrame(data)
+		if err != nil {
+			return nil, err
+		}
+		if frames > 1 {
+			logInfof("%s is an animated GIF with %d frames, only the first frame is used", name, frames)
+		}
+	}
+
+	img = applyOrientation(img, exifOrientation(data))
+
+	// Unify the orientation only once the image is upright
+	if needsTurn(img.Bounds(), g.cfg.NormalizeOrientation) {
+		img = applyOrientation(img, 6) // A clockwise quarter turn
+	}
+
+	// JPEG has no alpha channel, so transparent areas would turn black. PNG cells are flattened
+	// as well unless NoFlatten is set, so the color filters need not handle alpha.
+	if !g.cfg.NoFlatten {
+		img = flattenAlpha(img, g.cfg.Background)
+	}
+
+	// Flip after resizing, so fewer pixels have to be moved. A contained image is aligned to
+	// the opposite edge first, so it ends up at the aligned edge after flipping.
+	flipH, flipV := g.imageFlips(name)
+	align := g.cfg.Align
+	if (flipH && (align == "left" || align == "right")) || (flipV && (align == "top" || align == "bottom")) {
+		align = oppositeAlign[align]
+	}
+
+	width, height := g.imageWidth, g.imageHeight
+	interp := interpolations[g.cfg.Interp]
+	var resizedImg image.Image
+	switch g.cfg.Fit {
+	case "contain":
+		resizedImg = fitContain(img, width, height, g.cellBackground(), align, interp)
+	case "cover":
+		resizedImg = fitCover(img, width, height, interp)
+	default:
+		resizedImg = resize.Resize(width, height, img, interp)
+	}
+	if flipH || flipV {
+		resizedImg = flipImage(resizedImg, flipH, flipV)
+	}
+
+	// Adjust after resizing, so fewer pixels have to be processed
+	if g.cfg.Brightness != 0 || g.cfg.Contrast != 0 {
+		resizedImg = adjustBrightnessContrast(resizedImg, g.cfg.Brightness, g.cfg.Contrast)
+	}
+
+	// Convert after resizing, so the overlay added below can stay in color
+	if g.cfg.Grayscale {
+		resizedImg = toGrayscale(resizedImg)
+	} else if g.cfg.Sepia {
+		resizedImg = toSepia(resizedImg)
+	}
+
+	if g.logo != nil {
+		resizedImg = g.addLogoOverlay(resizedImg, g.logo)
+	} else if g.cfg.Overlay || g.cfg.OverlayText != "" {
+		resizedImg = g.addOverlay(resizedImg, g.overlayLabel(name))
+	}
+
+	if g.cfg.QR {
+		if resizedImg, err = g.addQRCode(resizedImg, g.qrData(name)); err != nil {
+			return nil, fmt.Errorf("failed to create QR code: %w", err)
+		}
+	}
+
+	// Round last, so the corners of the overlay are cut off as well
+	if g.cfg.CornerRadius > 0 {
+		b := resizedImg.Bounds()
+		resizedImg = roundCorners(resizedImg, g.cfg.CornerRadius*float64(min(b.Dx(), b.Dy())), g.cellBackground())
+	}
+
+	var buf bytes.Buffer
+	if g.cfg.CellFormat == "png" {
+		err = png.Encode(&buf, to8Bit(resizedImg))
+	} else {
+		err = jpeg.Encode(&buf, resizedImg, &jpeg.Options{Quality: g.cfg.Quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// to8Bit converts img to 8 bits per channel, the resize package returns 16-bit images that the
+// PNG encoder would keep but gofpdf cannot embed.
+func to8Bit(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.RGBA, *image.Gray:
+		return img
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// cellBackground returns the color of the letterbox bars and rounded corners, transparent
+// when the transparency of the images is kept.
+func (g *Generator) cellBackground() color.Color {
+	if g.cfg.NoFlatten {
+		return color.Transparent
+	}
+	return g.cfg.Background
+}
+
+// cellImageType returns the gofpdf image type of the encoded images.
+func (g *Generator) cellImageType() string {
+	if g.cfg.CellFormat == "png" {
+		return "PNG"
+	}
+	return "JPEG"
+}
+
+// cellExt returns the file extension of the encoded images.
+func (g *Generator) cellExt() string {
+	if g.cfg.CellFormat == "png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// isMultiPageTIFF reports whether the TIFF file has more than one image file directory.
+func isMultiPageTIFF(r io.ReaderAt) bool {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return false
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(header[:2]) == "MM" {
+		order = binary.BigEndian
+	}
+
+	// The first directory starts with its entry count, followed by 12-byte entries
+	// and the offset of the next directory (0 when there is none)
+	ifdOffset := int64(order.Uint32(header[4:]))
+	count := make([]byte, 2)
+	if _, err := r.ReadAt(count, ifdOffset); err != nil {
+		return false
+	}
+	next := make([]byte, 4)
+	if _, err := r.ReadAt(next, ifdOffset+2+12*int64(order.Uint16(count))); err != nil {
+		return false
+	}
+	return order.Uint32(next) != 0
+}
+
+// decodeGIFFirstFrame decodes all frames of a GIF and returns the first one drawn onto the
+// logical screen, along with the number of frames. The first frame of a GIF may only cover
+// part of the screen.
+func decodeGIFFirstFrame(data []byte) (image.Image, int, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(g.Image) == 0 {
+		return nil, 0, fmt.Errorf("GIF has no frames")
+	}
+
+	frame := g.Image[0]
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		screen = frame.Bounds()
+	}
+
+	// Start from a transparent screen, so uncovered areas end up as the background color
+	rgba := image.NewRGBA(screen)
+	draw.Draw(rgba, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+	return rgba, len(g.Image), nil
+}
+
+// exifOrientation returns the EXIF orientation tag of the image data, or 1 (upright)
+// when the image has no EXIF data.
+func exifOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+// needsTurn reports whether an image of the given bounds has to be turned by 90° to be in the
+// orientation (portrait or landscape), square images never do.
+func needsTurn(bounds image.Rectangle, orientation string) bool {
+	switch orientation {
+	case "portrait":
+		return bounds.Dx() > bounds.Dy()
+	case "landscape":
+		return bounds.Dy() > bounds.Dx()
+	}
+	return false
+}
+
+// applyOrientation rotates and flips img so it is upright according to the EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 { // Orientations 5-8 swap width and height
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotated 90° counter-clockwise, needs a clockwise turn
+				dx, dy = h-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° clockwise, needs a counter-clockwise turn
+				dx, dy = y, w-1-x
+			}
+			si, di := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
+
+// flattenAlpha composites img onto a solid background color. Fully opaque images are
+// returned unchanged.
+func flattenAlpha(img image.Image, bg color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	return rgba
+}
+
+// adjustBrightnessContrast applies a linear transform to every color channel. Brightness
+// shifts the channels by a percentage of the full range, contrast scales them around the
+// midpoint by a percentage (-100 makes the image flat gray).
+func adjustBrightnessContrast(img image.Image, brightness, contrast float64) image.Image {
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	// Every channel value maps to the same output, so compute the transform once
+	var lut [256]uint8
+	factor := 1 + contrast/100
+	offset := brightness / 100 * 255
+	for v := range lut {
+		adjusted := (float64(v)-128)*factor + 128 + offset
+		lut[v] = uint8(math.Max(0, math.Min(255, math.Round(adjusted))))
+	}
+
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i] = lut[rgba.Pix[i]]
+		rgba.Pix[i+1] = lut[rgba.Pix[i+1]]
+		rgba.Pix[i+2] = lut[rgba.Pix[i+2]]
+	}
+
+	return rgba
+}
+
+// roundCorners replaces the area outside circular arcs of the given radius (in pixels) at
+// the corners of img with the background color, blending the edge of the arcs. A transparent
+// background cuts the corners out.
+func roundCorners(img image.Image, radius float64, bg color.Color) image.Image {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+
+	// Blend all four channels, the pixels are premultiplied by their alpha
+	br, bgG, bb, ba := bg.RGBA()
+	bgPix := [4]float64{float64(br >> 8), float64(bgG >> 8), float64(bb >> 8), float64(ba >> 8)}
+	w, h := float64(b.Dx()), float64(b.Dy())
+
+	for y := 0; y < rgba.Bounds().Dy(); y++ {
+		for x := 0; x < rgba.Bounds().Dx(); x++ {
+			// Distance from the pixel center to the center of the nearest corner arc
+			px, py := float64(x)+0.5, float64(y)+0.5
+			cx := math.Max(radius, math.Min(w-radius, px))
+			cy := math.Max(radius, math.Min(h-radius, py))
+			dist := math.Hypot(px-cx, py-cy)
+			coverage := math.Max(0, math.Min(1, radius-dist+0.5))
+			if coverage == 1 {
+				continue
+			}
+			i := rgba.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				rgba.Pix[i+c] = uint8(math.Round(float64(rgba.Pix[i+c])*coverage + bgPix[c]*(1-coverage)))
+			}
+		}
+	}
+
+	return rgba
+}
+
+// toGrayscale converts img to an 8-bit grayscale image using the luminance of each pixel.
+func toGrayscale(img image.Image) image.Image {
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray
+}
+
+// toSepia tones img in sepia with the classic weighting matrix, clamping the channels to 255.
+func toSepia(img image.Image) image.Image {
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	clamp := func(v float64) uint8 { return uint8(math.Min(255, math.Round(v))) }
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		r, g, b := float64(rgba.Pix[i]), float64(rgba.Pix[i+1]), float64(rgba.Pix[i+2])
+		rgba.Pix[i] = clamp(0.393*r + 0.769*g + 0.189*b)
+		rgba.Pix[i+1] = clamp(0.349*r + 0.686*g + 0.168*b)
+		rgba.Pix[i+2] = clamp(0.272*r + 0.534*g + 0.131*b)
+	}
+
+	return rgba
+}
+
+// oppositeAlign maps each edge alignment to the opposite edge.
+var oppositeAlign = map[string]string{"left": "right", "right": "left", "top": "bottom", "bottom": "top"}
+
+// imageFlips reports whether the image named name is mirrored horizontally and vertically
+// with RandomFlip. The choice is derived from the flip seed and the name rather than drawn
+// from the shared random source, so it does not depend on the order the workers run in and
+// is reproducible with a fixed seed. Each direction is flipped with even chance.
+func (g *Generator) imageFlips(name string) (horizontal, vertical bool) {
+	if !g.cfg.RandomFlip {
+		return false, false
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%s", g.flipSeed, name)
+	sum := h.Sum64() // The high bits depend on the whole input, the low ones mostly on its end
+	return sum>>63 != 0, g.cfg.RandomFlipVertical && sum>>62&1 != 0
+}
+
+// flipImage mirrors img horizontally (left to right) and/or vertically (top to bottom).
+func flipImage(img image.Image, horizontal, vertical bool) image.Image {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y < b.Dy(); y++ {
+		sy := y
+		if vertical {
+			sy = b.Dy() - 1 - y
+		}
+		for x := 0; x < b.Dx(); x++ {
+			sx := x
+			if horizontal {
+				sx = b.Dx() - 1 - x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// fitContain scales img to fit inside a width x height rectangle while keeping its aspect
+// ratio, and places it on a rectangle filled with the background color. The image is
+// centered unless align pushes it to the top, bottom, left or right edge.
+func fitContain(img image.Image, width, height uint, bg color.Color, align string, interp resize.InterpolationFunction) image.Image {
+	b := img.Bounds()
+	scale := math.Min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	scaled := resize.Resize(min(scaledSide(b.Dx(), scale), width), min(scaledSide(b.Dy(), scale), height), img, interp)
+
+	rgba := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	// Center the scaled image on the rectangle, or push it to the aligned edge
+	offset := image.Pt((int(width)-scaled.Bounds().Dx())/2, (int(height)-scaled.Bounds().Dy())/2)
+	switch align {
+	case "top":
+		offset.Y = 0
+	case "bottom":
+		offset.Y = int(height) - scaled.Bounds().Dy()
+	case "left":
+		offset.X = 0
+	case "right":
+		offset.X = int(width) - scaled.Bounds().Dx()
+	}
+	draw.Draw(rgba, scaled.Bounds().Sub(scaled.Bounds().Min).Add(offset), scaled, scaled.Bounds().Min, draw.Src)
+
+	return rgba
+}
+
+// fitCover scales img so it fills a width x height rectangle while keeping its aspect
+// ratio, and crops the overflow around the center.
+func fitCover(img image.Image, width, height uint, interp resize.InterpolationFunction) image.Image {
+	b := img.Bounds()
+	scale := math.Max(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	scaled := resize.Resize(max(scaledSide(b.Dx(), scale), width), max(scaledSide(b.Dy(), scale), height), img, interp)
+
+	rgba := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+
+	// Crop the center of the scaled image
+	sb := scaled.Bounds()
+	srcPt := image.Pt(sb.Min.X+(sb.Dx()-int(width))/2, sb.Min.Y+(sb.Dy()-int(height))/2)
+	draw.Draw(rgba, rgba.Bounds(), scaled, srcPt, draw.Src)
+
+	return rgba
+}
+
+// scaledSide returns a side of n pixels scaled by scale, rounded to at least 1 pixel.
+func scaledSide(n int, scale float64) uint {
+	return uint(math.Max(1, math.Round(float64(n)*scale)))
+}
+
+func (g *Generator) addOverlay(img image.Image, label string) image.Image {
+	// Create a new image with the same dimensions as the resized image
+	rgba := image.NewRGBA(img.Bounds())
+
+	// Draw the original image onto the new RGBA image
+	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+
+	// Define the size of the white square overlay
+	squareSize := int(g.cfg.OverlaySize * float64(img.Bounds().Dx())) // Fraction of the image width
+
+	// Define the position of the square
+	rect := overlayRect(rgba.Bounds(), squareSize, g.cfg.OverlayPos)
+
+	// Draw the square, blending it over the image when it is translucent
+	alpha := image.NewUniform(color.Alpha{uint8(math.Round(g.cfg.OverlayAlpha * 255))})
+	draw.DrawMask(rgba, rect, image.NewUniform(g.cfg.OverlayFill), image.Point{}, alpha, image.Point{}, draw.Over)
+
+	// Draw the border as four strips along the inside of the square
+	border := image.NewUniform(g.cfg.OverlayBorder)
+	width := min(g.cfg.OverlayBorderWidth, (rect.Dx()+1)/2)
+	if width > 0 {
+		for _, strip := range []image.Rectangle{
+			image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+width), // top
+			image.Rect(rect.Min.X, rect.Max.Y-width, rect.Max.X, rect.Max.Y), // bottom
+			image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+width, rect.Max.Y), // left
+			image.Rect(rect.Max.X-width, rect.Min.Y, rect.Max.X, rect.Max.Y), // right
+		} {
+			draw.Draw(rgba, strip, border, image.Point{}, draw.Src)
+		}
+	}
+
+	if label != "" {
+		g.drawLabel(rgba, rect, label)
+	}
+
+	return rgba
+}
+
+// qrData returns the QRData of the image at path. {name} is replaced by the file name without
+// extension, {file} by the file name, {path} by the path and {n} by the image's number.
+func (g *Generator) qrData(path string) string {
+	base := filepath.Base(path)
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{file}", base,
+		"{path}", path,
+		"{n}", strconv.Itoa(g.numbers[path]),
+	).Replace(g.cfg.QRData)
+}
+
+// addQRCode stamps a QR code encoding data at the QR position of img. The code keeps its
+// white quiet zone, so it can be scanned on top of dark images.
+func (g *Generator) addQRCode(img image.Image, data string) (image.Image, error) {
+	qr, err := qrcode.New(data, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+
+	size := int(g.cfg.QRSize * float64(min(img.Bounds().Dx(), img.Bounds().Dy())))
+	code := qr.Image(size)
+	rect := overlayRect(rgba.Bounds(), code.Bounds().Dx(), g.cfg.QRPos)
+	draw.Draw(rgba, rect, code, code.Bounds().Min, draw.Src)
+
+	return rgba, nil
+}
+
+// overlayLabel returns the OverlayText of the image called name, with {n} replaced by its number.
+func (g *Generator) overlayLabel(name string) string {
+	return strings.ReplaceAll(g.cfg.OverlayText, "{n}", strconv.Itoa(g.numbers[name]))
+}
+
+// drawLabel draws text centered in rect in the border color, as large as fits in the square.
+func (g *Generator) drawLabel(dst draw.Image, rect image.Rectangle, text string) {
+	side := float64(rect.Dx())
+	size := 0.6 * side
+	face, err := opentype.NewFace(g.overlayFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		logWarnf("Failed to draw overlay text %q: %v", text, err)
+		return
+	}
+	// Shrink long labels to 80% of the square's width
+	if width := float64(font.MeasureString(face, text)) / 64; width > 0.8*side {
+		face.Close()
+		size *= 0.8 * side / width
+		if face, err = opentype.NewFace(g.overlayFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull}); err != nil {
+			logWarnf("Failed to draw overlay text %q: %v", text, err)
+			return
+		}
+	}
+	defer face.Close()
+
+	// Center the capital height, so digits and capitals sit in the middle of the square
+	width := font.MeasureString(face, text)
+	capHeight := face.Metrics().CapHeight
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(g.cfg.OverlayBorder),
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: fixed.I(rect.Min.X) + (fixed.I(rect.Dx())-width)/2,
+			Y: fixed.I(rect.Min.Y) + (fixed.I(rect.Dy())+capHeight)/2,
+		},
+	}
+	drawer.DrawString(text)
+}
+
+// loadLogo decodes the logo at path and scales it to fit inside a size x size square.
+func loadLogo(path string, size uint) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	logo, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return resize.Thumbnail(size, size, logo, resize.Lanczos3), nil
+}
+
+// addLogoOverlay composites the logo over the overlay position of img, honoring
+// the logo's transparency.
+func (g *Generator) addLogoOverlay(img, logo image.Image) image.Image {
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+
+	// Center the logo within the overlay square
+	squareSize := int(g.cfg.OverlaySize * float64(img.Bounds().Dx()))
+	rect := overlayRect(rgba.Bounds(), squareSize, g.cfg.OverlayPos)
+	offset := image.Pt((squareSize-logo.Bounds().Dx())/2, (squareSize-logo.Bounds().Dy())/2)
+	dst := logo.Bounds().Sub(logo.Bounds().Min).Add(rect.Min).Add(offset)
+
+	draw.Draw(rgba, dst, logo, logo.Bounds().Min, draw.Over)
+
+	return rgba
+}
+
+// overlayRect returns the rectangle of a size x size square anchored at pos
+// (tl, tr, bl, br or center) within bounds.
+func overlayRect(bounds image.Rectangle, size int, pos string) image.Rectangle {
+	var origin image.Point
+	switch pos {
+	case "tl":
+		origin = bounds.Min
+	case "tr":
+		origin = image.Pt(bounds.Max.X-size, bounds.Min.Y)
+	case "bl":
+		origin = image.Pt(bounds.Min.X, bounds.Max.Y-size)
+	case "center":
+		origin = image.Pt(bounds.Min.X+(bounds.Dx()-size)/2, bounds.Min.Y+(bounds.Dy()-size)/2)
+	default: // br
+		origin = image.Pt(bounds.Max.X-size, bounds.Max.Y-size)
+	}
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(size, size))}
+}
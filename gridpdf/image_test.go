@@ -0,0 +1,235 @@
+package gridpdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+// tiffHeader returns the start of a TIFF file whose first directory has the given number of
+// (zeroed) entries and is followed by the offset of the next directory.
+func tiffHeader(bigEndian bool, entries uint16, next uint32) []byte {
+	var order binary.AppendByteOrder = binary.LittleEndian
+	buf := []byte("II*\x00")
+	if bigEndian {
+		order, buf = binary.BigEndian, []byte("MM\x00*")
+	}
+	buf = order.AppendUint32(buf, 8)
+	buf = order.AppendUint16(buf, entries)
+	buf = append(buf, make([]byte, 12*int(entries))...)
+	return order.AppendUint32(buf, next)
+}
+
+func TestIsMultiPageTIFF(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"single page", tiffHeader(false, 3, 0), false},
+		{"two pages", tiffHeader(false, 3, 100), true},
+		{"big endian", tiffHeader(true, 2, 100), true},
+		{"big endian single page", tiffHeader(true, 2, 0), false},
+		{"truncated header", []byte("II*\x00"), false},
+		{"truncated directory", tiffHeader(false, 3, 100)[:20], false},
+	}
+	for _, tt := range tests {
+		if got := isMultiPageTIFF(bytes.NewReader(tt.data)); got != tt.want {
+			t.Errorf("%s: isMultiPageTIFF = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// markedImage returns a width x height image whose pixels have distinct colors, so tests can
+// follow where each pixel ends up.
+func markedImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(1 + x*50), uint8(1 + y*50), 0, 255})
+		}
+	}
+	return img
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// Where the top corners of a 3x2 image end up once it is shown upright
+	tests := []struct {
+		orientation       int
+		width, height     int
+		topLeft, topRight image.Point
+	}{
+		{1, 3, 2, image.Pt(0, 0), image.Pt(2, 0)},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(0, 0)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(0, 1)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(2, 1)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 2)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 2)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 0)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 0)},
+		{9, 3, 2, image.Pt(0, 0), image.Pt(2, 0)}, // Not a valid orientation, left alone
+	}
+	src := markedImage(3, 2)
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orientation %d: got a %dx%d image, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+			continue
+		}
+		if got.At(tt.topLeft.X, tt.topLeft.Y) != src.At(0, 0) || got.At(tt.topRight.X, tt.topRight.Y) != src.At(2, 0) {
+			t.Errorf("orientation %d: the top corners are not at %v and %v", tt.orientation, tt.topLeft, tt.topRight)
+		}
+	}
+}
+
+func TestDecodeGIFFirstFrame(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	palette := color.Palette{color.Transparent, red}
+	// The first frame only covers the middle of the 4x4 screen
+	first := image.NewPaletted(image.Rect(1, 1, 3, 3), palette)
+	second := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	for i := range first.Pix {
+		first.Pix[i] = 1
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:  []*image.Paletted{first, second},
+		Delay:  []int{10, 10},
+		Config: image.Config{ColorModel: palette, Width: 4, Height: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, frames, err := decodeGIFFirstFrame(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 2 {
+		t.Errorf("got %d frames, want 2", frames)
+	}
+	if b := img.Bounds(); b != image.Rect(0, 0, 4, 4) {
+		t.Errorf("got bounds %v, want the 4x4 screen", b)
+	}
+	if got := color.RGBAModel.Convert(img.At(1, 1)); got != red {
+		t.Errorf("pixel within the first frame is %v, want %v", got, red)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("pixel outside the first frame has alpha %d, want it transparent", a)
+	}
+
+	if _, _, err := decodeGIFFirstFrame([]byte("GIF89a")); err == nil {
+		t.Error("got no error for a truncated GIF")
+	}
+}
+
+func TestFlipImage(t *testing.T) {
+	// Where the top-left and bottom-right corners of a 3x2 image end up
+	tests := []struct {
+		horizontal, vertical bool
+		topLeft, bottomRight image.Point
+	}{
+		{false, false, image.Pt(0, 0), image.Pt(2, 1)},
+		{true, false, image.Pt(2, 0), image.Pt(0, 1)},
+		{false, true, image.Pt(0, 1), image.Pt(2, 0)},
+		{true, true, image.Pt(2, 1), image.Pt(0, 0)},
+	}
+	src := markedImage(3, 2)
+	for _, tt := range tests {
+		got := flipImage(src, tt.horizontal, tt.vertical)
+		if got.Bounds() != src.Bounds() {
+			t.Errorf("flip %v, %v: got bounds %v, want %v", tt.horizontal, tt.vertical, got.Bounds(), src.Bounds())
+			continue
+		}
+		if got.At(tt.topLeft.X, tt.topLeft.Y) != src.At(0, 0) || got.At(tt.bottomRight.X, tt.bottomRight.Y) != src.At(2, 1) {
+			t.Errorf("flip %v, %v: the corners are not at %v and %v", tt.horizontal, tt.vertical, tt.topLeft, tt.bottomRight)
+		}
+	}
+}
+
+func TestImageFlips(t *testing.T) {
+	tests := []struct {
+		name                         string
+		flip, vertical               bool
+		wantHorizontal, wantVertical bool // whether any of the images is flipped that way
+	}{
+		{"off", false, true, false, false},
+		{"horizontal only", true, false, true, false},
+		{"both directions", true, true, true, true},
+	}
+	for _, tt := range tests {
+		g := Generator{cfg: Config{RandomFlip: tt.flip, RandomFlipVertical: tt.vertical}, flipSeed: 3}
+		const n = 200
+		horizontal, vertical := 0, 0
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("img_%03d.jpg", i)
+			h, v := g.imageFlips(name)
+			if h2, v2 := g.imageFlips(name); h2 != h || v2 != v {
+				t.Fatalf("%s: %s flipped differently on the second call", tt.name, name)
+			}
+			if h {
+				horizontal++
+			}
+			if v {
+				vertical++
+			}
+		}
+		if (horizontal > 0) != tt.wantHorizontal || (vertical > 0) != tt.wantVertical {
+			t.Errorf("%s: %d of %d images flipped horizontally and %d vertically", tt.name, horizontal, n, vertical)
+		}
+		// Each direction is flipped with even chance
+		if tt.wantHorizontal && (horizontal < n/3 || horizontal > 2*n/3) {
+			t.Errorf("%s: %d of %d images flipped horizontally, want about half", tt.name, horizontal, n)
+		}
+		if tt.wantVertical && (vertical < n/3 || vertical > 2*n/3) {
+			t.Errorf("%s: %d of %d images flipped vertically, want about half", tt.name, vertical, n)
+		}
+	}
+
+	// Another seed flips other images
+	a, b := Generator{cfg: Config{RandomFlip: true}, flipSeed: 1}, Generator{cfg: Config{RandomFlip: true}, flipSeed: 2}
+	same := true
+	for i := 0; i < 20 && same; i++ {
+		name := fmt.Sprintf("img_%03d.jpg", i)
+		ha, _ := a.imageFlips(name)
+		hb, _ := b.imageFlips(name)
+		same = ha == hb
+	}
+	if same {
+		t.Error("seeds 1 and 2 flip the same images")
+	}
+}
+
+func TestRoundCorners(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	tests := []struct {
+		name   string
+		bg     color.Color
+		corner color.RGBA
+	}{
+		{"background color", white, white},
+		{"transparent", color.Transparent, color.RGBA{}},
+	}
+	src := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	red := color.RGBA{255, 0, 0, 255}
+	draw.Draw(src, src.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	for _, tt := range tests {
+		got := roundCorners(src, 5, tt.bg)
+		if c := color.RGBAModel.Convert(got.At(0, 0)); c != tt.corner {
+			t.Errorf("%s: corner pixel is %v, want %v", tt.name, c, tt.corner)
+		}
+		if c := color.RGBAModel.Convert(got.At(10, 10)); c != red {
+			t.Errorf("%s: center pixel is %v, want %v", tt.name, c, red)
+		}
+		// The edge of the arc is blended, here about half covered
+		_, _, _, a := got.At(1, 1).RGBA()
+		if _, _, _, bgA := tt.bg.RGBA(); bgA == 0 && (a == 0 || a == 0xffff) {
+			t.Errorf("%s: pixel on the arc has alpha %d, want it partially transparent", tt.name, a)
+		}
+	}
+}
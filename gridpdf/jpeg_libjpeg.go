@@ -0,0 +1,126 @@
+//go:build cgo && libjpeg
+
+package gridpdf
+
+// Go's JPEG decoder always decodes at full size. libjpeg can skip most of the work by
+// decoding at 1/2, 1/4 or 1/8 of the size straight from the DCT coefficients, which needs
+// the system libjpeg (or libjpeg-turbo) headers, so it is only built with the libjpeg tag.
+
+/*
+#cgo LDFLAGS: -ljpeg
+#include <stdio.h>
+#include <stdlib.h>
+#include <setjmp.h>
+#include <jpeglib.h>
+
+struct error_mgr {
+	struct jpeg_error_mgr pub;
+	jmp_buf jmp;
+	char msg[JMSG_LENGTH_MAX];
+};
+
+static void error_exit(j_common_ptr cinfo) {
+	struct error_mgr *err = (struct error_mgr *)cinfo->err;
+	(*cinfo->err->format_message)(cinfo, err->msg);
+	longjmp(err->jmp, 1);
+}
+
+// Corrupt data warnings would otherwise be printed to stderr
+static void output_message(j_common_ptr cinfo) {}
+
+// decode_scaled decodes the JPEG in data to RGB at the smallest scale that keeps both sides
+// at least min_size pixels. It returns 0 on success with the pixels in *pixels (freed by the
+// caller), 1 on a decoding error described in msg and 2 for CMYK images.
+static int decode_scaled(unsigned char *data, unsigned long size, int min_size,
+		unsigned char **pixels, int *width, int *height, char *msg) {
+	struct jpeg_decompress_struct cinfo;
+	struct error_mgr err;
+	unsigned char *volatile buf = NULL;
+
+	cinfo.err = jpeg_std_error(&err.pub);
+	err.pub.error_exit = error_exit;
+	err.pub.output_message = output_message;
+	if (setjmp(err.jmp)) {
+		free(buf);
+		jpeg_destroy_decompress(&cinfo);
+		snprintf(msg, JMSG_LENGTH_MAX, "%s", err.msg);
+		return 1;
+	}
+
+	jpeg_create_decompress(&cinfo);
+	jpeg_mem_src(&cinfo, data, size);
+	jpeg_read_header(&cinfo, TRUE);
+	if (cinfo.jpeg_color_space == JCS_CMYK || cinfo.jpeg_color_space == JCS_YCCK) {
+		jpeg_destroy_decompress(&cinfo);
+		return 2;
+	}
+
+	cinfo.out_color_space = JCS_RGB;
+	cinfo.scale_num = 1;
+	cinfo.scale_denom = 1;
+	for (int denom = 8; denom > 1; denom /= 2) {
+		if (cinfo.image_width / denom >= (unsigned)min_size && cinfo.image_height / denom >= (unsigned)min_size) {
+			cinfo.scale_denom = denom;
+			break;
+		}
+	}
+
+	jpeg_start_decompress(&cinfo);
+	size_t stride = (size_t)cinfo.output_width * 3;
+	buf = malloc(stride * cinfo.output_height);
+	if (buf == NULL) {
+		jpeg_destroy_decompress(&cinfo);
+		snprintf(msg, JMSG_LENGTH_MAX, "out of memory");
+		return 1;
+	}
+	while (cinfo.output_scanline < cinfo.output_height) {
+		JSAMPROW row = buf + stride * cinfo.output_scanline;
+		jpeg_read_scanlines(&cinfo, &row, 1);
+	}
+	jpeg_finish_decompress(&cinfo);
+
+	*width = cinfo.output_width;
+	*height = cinfo.output_height;
+	*pixels = buf;
+	jpeg_destroy_decompress(&cinfo);
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+)
+
+// decodeJPEGScaled decodes JPEG data at the smallest DCT scale (1/1 to 1/8) that keeps both
+// sides at least minSize pixels. It returns a nil image without error for CMYK JPEGs,
+// which are left to the full decoder.
+func decodeJPEGScaled(data []byte, minSize int) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty JPEG data")
+	}
+	cdata := C.CBytes(data)
+	defer C.free(cdata)
+
+	var pixels *C.uchar
+	var width, height C.int
+	msg := (*C.char)(C.malloc(C.JMSG_LENGTH_MAX))
+	defer C.free(unsafe.Pointer(msg))
+	switch C.decode_scaled((*C.uchar)(cdata), C.ulong(len(data)), C.int(minSize), &pixels, &width, &height, msg) {
+	case 1:
+		return nil, errors.New("jpeg: " + C.GoString(msg))
+	case 2:
+		return nil, nil
+	}
+	defer C.free(unsafe.Pointer(pixels))
+
+	w, h := int(width), int(height)
+	rgb := unsafe.Slice((*byte)(unsafe.Pointer(pixels)), w*h*3)
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, j := 0, 0; i < len(rgb); i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = rgb[i], rgb[i+1], rgb[i+2], 255
+	}
+	return img, nil
+}
@@ -0,0 +1,63 @@
+//go:build cgo && libjpeg
+
+package gridpdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// encodeTestJPEG returns a width x height JPEG filled with c.
+func encodeTestJPEG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeJPEGScaled(t *testing.T) {
+	data := encodeTestJPEG(t, 800, 600, color.RGBA{200, 40, 40, 255})
+	tests := []struct {
+		minSize               int
+		wantWidth, wantHeight int
+	}{
+		{50, 100, 75},   // 1/8
+		{100, 200, 150}, // 1/8 would make the height 75
+		{300, 400, 300}, // 1/2 keeps the height at exactly minSize
+		{400, 800, 600}, // Full size
+	}
+	for _, tt := range tests {
+		img, err := decodeJPEGScaled(data, tt.minSize)
+		if err != nil {
+			t.Fatalf("minSize %d: %v", tt.minSize, err)
+		}
+		if got := img.Bounds(); got.Dx() != tt.wantWidth || got.Dy() != tt.wantHeight {
+			t.Errorf("minSize %d: got %dx%d, want %dx%d", tt.minSize, got.Dx(), got.Dy(), tt.wantWidth, tt.wantHeight)
+		}
+		r, g, b, a := img.At(tt.wantWidth/2, tt.wantHeight/2).RGBA()
+		if r>>8 < 190 || g>>8 > 50 || b>>8 > 50 || a>>8 != 255 {
+			t.Errorf("minSize %d: center pixel is %d,%d,%d,%d, want about 200,40,40,255", tt.minSize, r>>8, g>>8, b>>8, a>>8)
+		}
+	}
+}
+
+func TestDecodeJPEGScaledErrors(t *testing.T) {
+	data := encodeTestJPEG(t, 64, 64, color.White)
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"not jpeg":  []byte("not a jpeg at all"),
+		"truncated": data[:20],
+	} {
+		if img, err := decodeJPEGScaled(data, 8); err == nil {
+			t.Errorf("%s: got a %v image, want an error", name, img.Bounds())
+		}
+	}
+}
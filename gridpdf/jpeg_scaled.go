@@ -0,0 +1,11 @@
+//go:build !cgo || !libjpeg
+
+package gridpdf
+
+import "image"
+
+// decodeJPEGScaled needs libjpeg, so without the libjpeg build tag it returns a nil image
+// and JPEGs are decoded at full size.
+func decodeJPEGScaled(data []byte, minSize int) (image.Image, error) {
+	return nil, nil
+}
@@ -0,0 +1,108 @@
+package gridpdf
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// logLevel orders messages by severity, only those at or above the current level print
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelQuiet // Like error, for scripts that only want to hear about failures
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+	"quiet": levelQuiet,
+}
+
+// logger is the single destination for all messages, so verbosity is controlled in one place
+var logger = struct {
+	level    logLevel
+	progress bool // print the in-place progress updates
+	midLine  bool // the last progress update did not end its line
+	out      *log.Logger
+}{levelInfo, true, false, log.New(os.Stderr, "", log.LstdFlags)}
+
+// setLogLevel sets the verbosity from its name (debug, info, warn, error or quiet)
+func setLogLevel(name string) error {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn, error or quiet", name)
+	}
+	logger.level = level
+	return nil
+}
+
+// logEnabled reports whether messages at level print
+func logEnabled(level logLevel) bool {
+	return level >= logger.level
+}
+
+func logf(level logLevel, format string, args ...any) {
+	if logEnabled(level) {
+		logger.out.Printf(format, args...)
+	}
+}
+
+func logDebugf(format string, args ...any) { logf(levelDebug, format, args...) }
+func logInfof(format string, args ...any)  { logf(levelInfo, format, args...) }
+func logWarnf(format string, args ...any)  { logf(levelWarn, format, args...) }
+
+// logFatalf logs an error and exits, it prints at every level
+func logFatalf(format string, args ...any) {
+	endProgressLine()
+	logger.out.Fatalf(format, args...)
+}
+
+// progressf writes in-place progress updates to stdout at info level, unless progress output
+// is turned off
+func progressf(format string, args ...any) {
+	if logger.progress && logEnabled(levelInfo) {
+		s := fmt.Sprintf(format, args...)
+		fmt.Print(s)
+		logger.midLine = !strings.HasSuffix(s, "\n")
+	}
+}
+
+// endProgressLine moves to a new line after an unfinished progress update, so a message logged
+// after it starts on a line of its own.
+func endProgressLine() {
+	if logger.midLine {
+		progressf("\n")
+	}
+}
+
+// SetLogLevel sets the verbosity of the package's log output by name (debug, info, warn,
+// error or quiet). It applies to all Generators.
+func SetLogLevel(name string) error {
+	return setLogLevel(name)
+}
+
+// SetProgress turns the in-place progress updates on stdout on or off. Set the LoadProgress
+// and PageProgress callbacks of a Config to receive them instead.
+func SetProgress(enabled bool) {
+	logger.progress = enabled
+}
+
+// SetLogOutput redirects the package's log messages, which go to stderr by default.
+func SetLogOutput(w io.Writer) {
+	logger.out.SetOutput(w)
+}
+
+// Infof, Warnf and Fatalf log through the package's logger, so a program using it prints
+// its own messages in the same format and at the same log level.
+func Infof(format string, args ...any)  { logInfof(format, args...) }
+func Warnf(format string, args ...any)  { logWarnf(format, args...) }
+func Fatalf(format string, args ...any) { logFatalf(format, args...) }
@@ -0,0 +1,33 @@
+package gridpdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// manifestPage records which image was placed in which cell of a grid page.
+type manifestPage struct {
+	Page   int            `json:"page"`             // grid page number, starting at 1
+	Serial *int           `json:"serial,omitempty"` // serial number stamped on the page, if enabled
+	Cells  []manifestCell `json:"cells"`
+}
+
+// manifestCell is a single placed image. Free and blank cells are not recorded.
+type manifestCell struct {
+	Row  int    `json:"row"` // starting at 1 at the top
+	Col  int    `json:"col"` // starting at 1 on the left
+	File string `json:"file"`
+}
+
+// writeManifest saves the placement records as indented JSON.
+func writeManifest(path string, pages []manifestPage) error {
+	data, err := json.MarshalIndent(pages, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
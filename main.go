@@ -1,225 +1,437 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha1"
+	"context"
 	"flag"
 	"fmt"
-	"image"
 	"image/color"
-	"image/draw"
-	"image/jpeg"
-	"log"
-	"math/rand"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strconv"
-	"sync"
+	"strings"
+	"unicode"
 
-	"github.com/jung-kurt/gofpdf/v2"
-	"github.com/nfnt/resize"
-)
-
-const (
-	gridRows = 5
-	gridCols = 5
-)
-
-var (
-	imgSize       = 50.0 // size of each image in the grid (in points, for PDF)
-	marginTop     = 10.0 // top margin
-	marginLeft    = 10.0 // left margin
-	cellSpacing   = 2.0  // spacing between cells
-	overlaySquare = flag.Bool("overlay", false, "Overlay a white square with a black border on the bottom right of each image")
+	"imagesToGridPdf/gridpdf"
 )
 
 func main() {
+	cfg := gridpdf.DefaultConfig()
+
+	flag.BoolVar(&cfg.Overlay, "overlay", false, "Overlay a white square with a black border on the bottom right of each image")
+	flag.Float64Var(&cfg.OverlayAlpha, "overlay-alpha", cfg.OverlayAlpha, "Opacity (0-1) of the overlay square's fill, so the image shows through; the border stays opaque")
+	flag.Float64Var(&cfg.OverlaySize, "overlay-size", cfg.OverlaySize, "Size of the overlay square as a fraction (0-1] of the image width")
+	overlayFill := flag.String("overlay-fill", "", "Fill color (hex) of the overlay square (default white)")
+	overlayBorder := flag.String("overlay-border", "", "Border color (hex) of the overlay square (default black)")
+	flag.IntVar(&cfg.OverlayBorderWidth, "overlay-border-width", cfg.OverlayBorderWidth, "Width of the overlay square's border in pixels (0 for none), so it stands out on busy images")
+	flag.StringVar(&cfg.OverlayPos, "overlay-pos", cfg.OverlayPos, "Position of the overlay square (tl, tr, bl, br, center)")
+	flag.StringVar(&cfg.OverlayText, "overlay-text", "", "Text printed in the overlay square (implies --overlay), {n} is replaced by each image's number")
+	flag.BoolVar(&cfg.QR, "qr", false, "Stamp a QR code on each image, e.g. to link printed sheets back to the originals")
+	flag.StringVar(&cfg.QRData, "qr-data", cfg.QRData, "Text encoded in the QR code, {name}, {file}, {path} and {n} are replaced by the image's name, file name, path and number")
+	flag.Float64Var(&cfg.QRSize, "qr-size", cfg.QRSize, "Size of the QR code as a fraction (0-1] of the image's shorter side")
+	flag.StringVar(&cfg.QRPos, "qr-pos", cfg.QRPos, "Position of the QR code (tl, tr, bl, br, center)")
+	flag.StringVar(&cfg.LogoPath, "logo", "", "Path to a PNG/JPEG logo stamped in the overlay position instead of the plain square")
+	flag.IntVar(&cfg.Rows, "rows", cfg.Rows, "Number of rows in the grid on each page")
+	flag.IntVar(&cfg.Cols, "cols", cfg.Cols, "Number of columns in the grid on each page")
+	flag.Float64Var(&cfg.MarginTop, "margin-top", cfg.MarginTop, "Top page margin in mm")
+	flag.Float64Var(&cfg.MarginBottom, "margin-bottom", cfg.MarginBottom, "Bottom page margin in mm")
+	flag.Float64Var(&cfg.MarginLeft, "margin-left", cfg.MarginLeft, "Left page margin in mm")
+	flag.Float64Var(&cfg.MarginRight, "margin-right", cfg.MarginRight, "Right page margin in mm")
+	flag.Float64Var(&cfg.Bleed, "bleed", 0, "Bleed in mm added to the page on all sides for professional printing; margins are measured from the trim line and a margin of 0 runs the grid into the bleed")
+	flag.Float64Var(&cfg.CellSize, "cell-size", 0, "Width of the grid cells in mm; fits as many rows and columns as the page holds, centered, instead of --rows and --cols")
+	flag.Float64Var(&cfg.CellSpacing, "spacing", cfg.CellSpacing, "Spacing between grid cells in mm (0 for a tight mosaic)")
+	flag.Float64Var(&cfg.CellPadding, "cell-padding", 0, "Padding in mm between the edges of each cell and its image; the cells keep their size and --cell-border frames the padding")
+	cellAspect := flag.String("cell-aspect", "1:1", "Aspect ratio (width:height) of the grid cells and images, e.g. 3:4 for portrait photos; other than 1:1 the rows are recomputed to fill the page height (replaces --rows)")
+	flag.BoolVar(&cfg.AutoGrid, "auto-grid", false, "Pick rows and columns so all images fit once on each page, as squarely as possible (overrides --rows and --cols)")
+	flag.BoolVar(&cfg.Mosaic, "mosaic", false, "Tile the images edge to edge over the whole page: no margins or spacing, cells stretched to divide the page evenly, no captions or overlays")
+	flag.BoolVar(&cfg.ContactSheet, "contact-sheet", false, "Place every image exactly once in folder order, on as many pages as needed (ignores <number_of_pages>)")
+	flag.StringVar(&cfg.FillLast, "fill-last", cfg.FillLast, "How the empty cells of partially filled pages (contact sheet, auto grid) are used: blank, repeat earlier images, or stretch the images to fill the page")
+	flag.StringVar(&cfg.PageSize, "pagesize", cfg.PageSize, "Page size of the PDF (A1-A6, Letter, Legal, Tabloid)")
+	flag.BoolVar(&cfg.Landscape, "landscape", false, "Use landscape page orientation instead of portrait")
+	flag.StringVar(&cfg.Orientations, "orientations", "", "Orientation pattern of the grid pages, repeated across the document, e.g. PL to alternate portrait and landscape (overrides --landscape)")
+	flag.StringVar(&cfg.Fit, "fit", cfg.Fit, "How images are fitted into the square cells (stretch, contain, cover)")
+	flag.StringVar(&cfg.Align, "align", cfg.Align, "Where images sit within their cells in contain mode (center, top, bottom, left, right)")
+	flag.StringVar(&cfg.Interp, "interp", cfg.Interp, "Interpolation used to resize images (nearest, bilinear, bicubic, lanczos2, lanczos3); bilinear is much faster on large folders")
+	bgColor := flag.String("bgcolor", "#ffffff", "Background color (hex) used to letterbox images in contain mode and behind transparent areas")
+	seed := flag.Int64("seed", 0, "Seed for the image shuffling, so the same seed reproduces the same sheets (default: time based, logged and recorded in the PDF)")
+	flag.BoolVar(&cfg.NoShuffle, "no-shuffle", false, "Place images in load order instead of shuffling them on every page")
+	flag.BoolVar(&cfg.UniquePages, "unique-pages", false, "Reshuffle until every page has a different image arrangement")
+	flag.BoolVar(&cfg.StaticLayout, "static-layout", false, "Shuffle once and repeat the same arrangement on every page")
+	flag.IntVar(&cfg.MaxReshuffles, "unique-attempts", cfg.MaxReshuffles, "Maximum reshuffles per page when --unique-pages is set")
+	flag.BoolVar(&cfg.FreeCenter, "free-center", false, "Leave the center cell free instead of placing an image (requires odd rows and columns)")
+	flag.StringVar(&cfg.FreeLabel, "free-label", cfg.FreeLabel, "Text drawn in the free center cell (empty leaves it blank)")
+	flag.IntVar(&cfg.SerialStart, "serial-start", 0, "Stamp an incrementing serial number on each page, starting at this value")
+	flag.IntVar(&cfg.SerialWidth, "serial-width", cfg.SerialWidth, "Zero-padded width of the serial number")
+	flag.BoolVar(&cfg.RandomRotate, "random-rotate", false, "Rotate every image by a small random angle for a scattered collage look (reproducible with --seed)")
+	flag.Float64Var(&cfg.RandomRotateMax, "random-rotate-max", cfg.RandomRotateMax, "Maximum random rotation in degrees, in either direction")
+	flag.BoolVar(&cfg.RandomFlip, "random-flip", false, "Mirror a random half of the images horizontally so reused images look less repetitive (reproducible with --seed)")
+	flag.BoolVar(&cfg.RandomFlipVertical, "random-flip-vertical", false, "With --random-flip, also mirror a random half of the images vertically")
+	gridLines := flag.Bool("grid-lines", false, "Draw continuous lines around and between the grid cells")
+	gridLineColor := flag.String("grid-line-color", "#000000", "Color (hex) of the grid lines")
+	flag.Float64Var(&cfg.GridLineWidth, "grid-line-width", cfg.GridLineWidth, "Line width of the grid lines in mm")
+	shadow := flag.Bool("shadow", false, "Draw a drop shadow behind each image")
+	shadowColor := flag.String("shadow-color", "#999999", "Color (hex) of the drop shadow")
+	flag.Float64Var(&cfg.ShadowOffset, "shadow-offset", cfg.ShadowOffset, "Offset of the drop shadow to the bottom right in mm")
+	pageBg := flag.String("page-bg", "", "Color (hex) the grid pages are filled with behind the images (default white)")
+	flag.StringVar(&cfg.PageImage, "page-bg-image", "", "JPEG, PNG or GIF image covering each grid page behind the images, cropped to the page shape")
+	cellBorder := flag.String("cell-border", "", "Color (hex) of a border drawn around each image (default no border)")
+	flag.Float64Var(&cfg.CellBorderWidth, "cell-border-width", cfg.CellBorderWidth, "Line width of the cell border in mm")
+	flag.BoolVar(&cfg.CropMarks, "crop-marks", false, "Draw crop marks in the margins at the corners of the printable area")
+	flag.Float64Var(&cfg.CropMarkLength, "crop-mark-length", cfg.CropMarkLength, "Length of the crop marks in mm")
+	flag.Float64Var(&cfg.CropMarkOffset, "crop-mark-offset", cfg.CropMarkOffset, "Gap between the printable area and the crop marks in mm")
+	flag.StringVar(&cfg.Title, "title", "", "Add a cover page with this title before the grid pages")
+	flag.StringVar(&cfg.Subtitle, "subtitle", "", "Subtitle printed below the title on the cover page")
+	flag.BoolVar(&cfg.PageNumbers, "page-numbers", false, "Print \"Page X of N\" in the bottom margin of each page")
+	flag.StringVar(&cfg.PageNumberAlign, "page-number-align", cfg.PageNumberAlign, "Alignment of the page numbers (left, center, right)")
+	flag.BoolVar(&cfg.CaptionFolders, "caption-folders", false, "With --captions, prefix each caption with the name of the folder the image was loaded from")
+	flag.StringVar(&cfg.FontPath, "font", "", "TrueType (.ttf) font for the captions, call sheet names and overlay text, e.g. for non-Latin file names (default Helvetica and Go Bold)")
+	flag.BoolVar(&cfg.Captions, "captions", false, "Print each image's file name as a caption beneath it")
+	flag.StringVar(&cfg.Watermark, "watermark", "", "Faint diagonal text drawn across every page, e.g. DRAFT")
+	flag.Float64Var(&cfg.WatermarkSize, "watermark-size", cfg.WatermarkSize, "Font size of the watermark in points")
+	flag.Float64Var(&cfg.WatermarkAngle, "watermark-angle", cfg.WatermarkAngle, "Rotation of the watermark in degrees, counter-clockwise")
+	flag.StringVar(&cfg.ManifestPath, "manifest", "", "Write a JSON file recording the source image placed in every cell of every page, e.g. as an answer key")
+	flag.StringVar(&cfg.CallSheetPath, "call-sheet", "", "Write a PDF listing every placed image with a thumbnail and its name, e.g. for a bingo caller")
+	flag.IntVar(&cfg.SplitEvery, "split-every", 0, "Write a separate PDF for every N pages (output_001.pdf, output_002.pdf, ...) instead of a single one")
+	flag.BoolVar(&cfg.RestartPageNumbers, "split-restart-numbers", false, "Restart the page numbers in every split PDF instead of continuing them")
+	flag.StringVar(&cfg.PDFTitle, "pdf-title", "", "Title stored in the PDF document properties")
+	flag.StringVar(&cfg.PDFAuthor, "pdf-author", "", "Author stored in the PDF document properties")
+	flag.StringVar(&cfg.PDFSubject, "pdf-subject", "", "Subject stored in the PDF document properties")
+	flag.StringVar(&cfg.PDFKeywords, "pdf-keywords", "", "Space separated keywords stored in the PDF document properties")
+	flag.StringVar(&cfg.Password, "password", "", "Encrypt the PDF and require this password to open it")
+	flag.StringVar(&cfg.OwnerPassword, "owner-password", "", "Password granting full access to the encrypted PDF (default: random)")
+	flag.StringVar(&cfg.ListFile, "list", "", "Load the images listed in this text file (one path per line, in order) instead of an image folder")
+	flag.BoolVar(&cfg.Recursive, "recursive", false, "Also load images from subfolders of the image folder")
+	flag.StringVar(&cfg.Pattern, "pattern", "", "Only load images whose file name matches this glob pattern, e.g. IMG_*.jpg")
+	exclude := flag.String("exclude", "", "Skip images whose file name matches one of these comma-separated glob patterns, e.g. \"*.thumb.jpg,draft_*\"")
+	flag.StringVar(&cfg.Sort, "sort", "", "Sort the loaded images before placing them (name, name-desc, mtime, size)")
+	flag.IntVar(&cfg.MaxImages, "max-images", 0, "Only load the first N images of the folder (0 loads all)")
+	flag.BoolVar(&cfg.Stream, "stream", false, "Resize images on demand while generating the PDF instead of preloading them, lowering peak memory use")
+	flag.StringVar(&cfg.CacheDir, "cache-dir", "", "Folder to cache resized images in, so later runs with the same options skip resizing unchanged images")
+	flag.IntVar(&cfg.Workers, "workers", cfg.Workers, "Maximum number of images decoded and resized concurrently")
+	flag.StringVar(&cfg.ErrorLogPath, "error-log", "", "Write the images that failed to load, one per line with the error, to this file")
+	flag.BoolVar(&cfg.Dedup, "dedup", false, "Place byte-identical copies of an image file only once")
+	flag.IntVar(&cfg.Retries, "retries", 0, "Retry images that fail to open or decode up to N times with a growing delay, e.g. on flaky network mounts (missing files and unknown formats are not retried)")
+	flag.StringVar(&cfg.NormalizeOrientation, "normalize-orientation", "", "Turn every image that is not in this orientation (portrait, landscape) a quarter turn clockwise, so crops look consistent")
+	flag.BoolVar(&cfg.Grayscale, "grayscale", false, "Convert images to grayscale for black-and-white printing (the overlay keeps its colors)")
+	flag.BoolVar(&cfg.Sepia, "sepia", false, "Tone images in sepia for an old-photo look (cannot be combined with --grayscale; the overlay keeps its colors)")
+	flag.Float64Var(&cfg.Brightness, "brightness", 0, "Brightness change in percent (-100 to 100)")
+	flag.Float64Var(&cfg.Contrast, "contrast", 0, "Contrast change in percent (at least -100), e.g. 20 for washed out scans")
+	flag.Float64Var(&cfg.CornerRadius, "corner-radius", 0, "Round the image corners with this radius, as a fraction (0-0.5] of the cell size, filling them with --bgcolor")
+	flag.Float64Var(&cfg.DPI, "dpi", cfg.DPI, "Resolution of the embedded images; images are resized to fill their cells at this DPI (300 for print quality)")
+	flag.StringVar(&cfg.CellFormat, "cell-format", cfg.CellFormat, "Encoding of the embedded images (jpeg, png); png is lossless, for sharp edges and text, but makes the PDF larger")
+	flag.BoolVar(&cfg.NoFlatten, "no-flatten", false, "Keep the transparency of the images with --cell-format png instead of filling it with --bgcolor (cannot be combined with the color filters)")
+	maxFileSize := flag.String("max-filesize", "", "Lower the JPEG quality until the PDF fits in this size, e.g. 10MB (B, KB, MB, GB in powers of 1000)")
+	flag.IntVar(&cfg.Quality, "quality", cfg.Quality, "JPEG quality of the embedded images (1-100, higher quality increases PDF size)")
+	quiet := flag.Bool("quiet", !isTerminal(os.Stdout), "Hide the progress output but keep the log messages; on by default when stdout is not a terminal")
+	logLevel := flag.String("log-level", "info", "Verbosity of the log output (debug, info, warn, error, quiet); below info the progress output is hidden")
+	outputDir := flag.String("output-dir", "", "Save the PDF in this folder, named after the image folder (e.g. vacation.pdf), instead of taking the <output_pdf> argument")
+	emitThumbs := flag.String("emit-thumbs", "", "Write the resized images as JPEG or PNG files (see --cell-format) to this folder instead of generating a PDF (only takes the image folder argument)")
+	dryRun := flag.Bool("dry-run", false, "Find the images and print the grid and page layout without generating the PDF")
+	showVersion := flag.Bool("version", false, "Print the version and exit")
 	flag.Parse()
 
-	if len(flag.Args()) != 3 {
-		fmt.Println("Usage: go run main.go [--overlay] <image_folder_path> <number_of_pages> <output_pdf>")
-		return
+	if err := gridpdf.SetLogLevel(*logLevel); err != nil {
+		gridpdf.Fatalf("Invalid log level: %v", err)
 	}
+	gridpdf.SetProgress(!*quiet)
 
-	imageFolder := flag.Args()[0]
-	numPages := atoi(flag.Args()[1])
-	outputPDF := flag.Args()[2]
-
-	log.Printf("Loading images from folder: %s", imageFolder)
-	images, err := loadAndResizeImages(imageFolder)
-	if err != nil {
-		log.Fatalf("Failed to load images from folder: %v", err)
+	if *showVersion {
+		fmt.Println(versionString())
+		return
 	}
 
-	if len(images) == 0 {
-		log.Fatalf("No images found in the specified folder.")
+	// All arguments before the number of pages and the output PDF are image folders, with
+	// --list there are none
+	args := flag.Args()
+	trailing := 2
+	if *emitThumbs != "" {
+		trailing = 0
+	} else if *outputDir != "" {
+		trailing = 1 // The output PDF is named after the folder, archive or list instead of given
+	}
+	folders := []string{""}
+	if cfg.ListFile == "" {
+		if len(args) <= trailing {
+			printUsage()
+			return
+		}
+		folders, args = args[:len(args)-trailing], args[len(args)-trailing:]
+	} else if len(args) != trailing {
+		printUsage()
+		return
+	}
+	if *outputDir != "" && *emitThumbs == "" {
+		source := folders[0]
+		if cfg.ListFile != "" {
+			source = cfg.ListFile
+		}
+		args = append(args, filepath.Join(*outputDir, outputFileName(source)))
 	}
 
-	fmt.Printf("\nGenerating PDF with %d pages\n", numPages)
-	generatePDF(images, numPages, outputPDF)
-	fmt.Printf("\nGenerated %d pages\n", numPages) // Move to a new line after the last update
-	log.Printf("PDF generated successfully: %s", outputPDF)
-}
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		clamped := clampInt(cfg.Quality, 1, 100)
+		gridpdf.Warnf("JPEG quality %d is out of range, using %d", cfg.Quality, clamped)
+		cfg.Quality = clamped
+	}
 
-func atoi(s string) int {
-	n, err := strconv.Atoi(s)
-	if err != nil {
-		log.Fatalf("Failed to convert string to int: %v", err)
+	for _, pattern := range strings.Split(*exclude, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			cfg.Exclude = append(cfg.Exclude, pattern)
+		}
 	}
-	return n
-}
 
-func loadAndResizeImages(folder string) ([][]byte, error) {
-	files, err := os.ReadDir(folder)
+	aspect, err := parseAspect(*cellAspect)
 	if err != nil {
-		return nil, err
+		gridpdf.Fatalf("Invalid cell aspect ratio: %v", err)
 	}
+	cfg.CellAspect = aspect
 
-	var images [][]byte
-	var wg sync.WaitGroup
-	imageChan := make(chan []byte, len(files))
-
-	totalFiles := len(files)
-	processedFiles := 0
-
-	for _, file := range files {
-		if !file.IsDir() && isImageFile(file.Name()) {
-			wg.Add(1)
-			go func(file os.DirEntry) {
-				defer wg.Done()
-				imagePath := filepath.Join(folder, file.Name())
-				imgData, err := resizeImage(imagePath)
-				if err != nil {
-					log.Printf("Failed to process image %s: %v", imagePath, err)
-					return
-				}
-				imageChan <- imgData
-				processedFiles++
-				fmt.Printf("\rLoaded and resized %d/%d images", processedFiles, totalFiles)
-			}(file)
+	if *maxFileSize != "" {
+		size, err := parseFileSize(*maxFileSize)
+		if err != nil {
+			gridpdf.Fatalf("Invalid max file size: %v", err)
 		}
+		cfg.MaxFileSize = size
 	}
 
-	go func() {
-		wg.Wait()
-		close(imageChan)
-	}()
+	bg, err := parseHexColor(*bgColor)
+	if err != nil {
+		gridpdf.Fatalf("Invalid background color: %v", err)
+	}
+	cfg.Background = bg
 
-	for imgData := range imageChan {
-		images = append(images, imgData)
+	if *overlayFill != "" {
+		fill, err := parseHexColor(*overlayFill)
+		if err != nil {
+			gridpdf.Fatalf("Invalid overlay fill color: %v", err)
+		}
+		cfg.OverlayFill = fill
+	}
+	if *overlayBorder != "" {
+		border, err := parseHexColor(*overlayBorder)
+		if err != nil {
+			gridpdf.Fatalf("Invalid overlay border color: %v", err)
+		}
+		cfg.OverlayBorder = border
+	}
+	if *pageBg != "" {
+		c, err := parseHexColor(*pageBg)
+		if err != nil {
+			gridpdf.Fatalf("Invalid page background color: %v", err)
+		}
+		cfg.PageColor = c
+	}
+	if *cellBorder != "" {
+		border, err := parseHexColor(*cellBorder)
+		if err != nil {
+			gridpdf.Fatalf("Invalid cell border color: %v", err)
+		}
+		cfg.CellBorder = border
+	}
+	if *gridLines {
+		c, err := parseHexColor(*gridLineColor)
+		if err != nil {
+			gridpdf.Fatalf("Invalid grid line color: %v", err)
+		}
+		cfg.GridLines = c
+	}
+	if *shadow {
+		c, err := parseHexColor(*shadowColor)
+		if err != nil {
+			gridpdf.Fatalf("Invalid shadow color: %v", err)
+		}
+		cfg.Shadow = c
 	}
 
-	fmt.Printf("\nLoaded and resized %d images\n", len(images)) // New line after all images are processed
-	return images, nil
-}
+	if isFlagSet("seed") {
+		cfg.Seed = seed
+	}
+	cfg.SerialNumbers = isFlagSet("serial-start")
 
-func isImageFile(filename string) bool {
-	ext := filepath.Ext(filename)
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp":
-		return true
-	default:
-		return false
+	cfg.ImageFolder, cfg.ExtraFolders = folders[0], folders[1:]
+	var generator gridpdf.Generator
+	if *emitThumbs != "" {
+		if err := generator.WriteThumbnails(context.Background(), cfg, *emitThumbs); err != nil {
+			gridpdf.Fatalf("Failed to write thumbnails: %v", err)
+		}
+		gridpdf.Infof("Thumbnails written to %s", *emitThumbs)
+		return
 	}
-}
 
-func resizeImage(imagePath string) ([]byte, error) {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return nil, err
+	// A contact sheet needs as many pages as its images fill, so the page count is not used
+	if !cfg.ContactSheet {
+		cfg.NumPages, err = atoi(args[0])
+		if err != nil {
+			gridpdf.Fatalf("Invalid number of pages: %v", err)
+		}
+		if cfg.NumPages < 1 {
+			gridpdf.Fatalf("Invalid number of pages %d: at least one page is required", cfg.NumPages)
+		}
+		if cfg.NumPages > largePageCount {
+			gridpdf.Warnf("Generating %d pages will take a while and produce a very large PDF", cfg.NumPages)
+		}
 	}
-	defer file.Close()
+	outputPDF := args[1]
 
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, err
+	if *dryRun {
+		plan, err := generator.DryRun(context.Background(), cfg)
+		if err != nil {
+			gridpdf.Fatalf("Dry run failed: %v", err)
+		}
+		cells := fmt.Sprintf("%.1f mm", plan.CellSize)
+		if plan.CellHeight != plan.CellSize {
+			cells = fmt.Sprintf("%.1fx%.1f mm", plan.CellSize, plan.CellHeight)
+		}
+		fmt.Printf("\nDry run: %d images, %dx%d grid with %s cells, %d images per page, %d pages\n",
+			plan.Images, plan.Rows, plan.Cols, cells, plan.CellsPerPage, plan.Pages)
+		return
+	}
+	if *outputDir != "" {
+		if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+			gridpdf.Fatalf("Failed to create output folder: %v", err)
+		}
 	}
+	if err := generator.GenerateFile(context.Background(), cfg, outputPDF); err != nil {
+		gridpdf.Fatalf("Failed to generate PDF: %v", err)
+	}
+	if cfg.SplitEvery > 0 {
+		gridpdf.Infof("PDFs generated successfully: %s, ...", gridpdf.SplitFileName(outputPDF, 1))
+		return
+	}
+	gridpdf.Infof("PDF generated successfully: %s", outputPDF)
+}
 
-	cellSize := uint(imgSize)
-	resizedImg := resize.Resize(cellSize, cellSize, img, resize.Lanczos3)
+// version and buildDate are set at build time, e.g.
+// go build -ldflags "-X main.version=1.2.0 -X main.buildDate=$(date -u +%Y-%m-%d)".
+var (
+	version   = "dev"
+	buildDate = ""
+)
 
-	if *overlaySquare {
-		resizedImg = addOverlay(resizedImg)
+// versionString returns the version along with the build date, the Go version and, when
+// built from a git checkout, the commit and its date.
+func versionString() string {
+	var details []string
+	if buildDate != "" {
+		details = append(details, "built "+buildDate)
 	}
-
-	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, resizedImg, nil)
-	if err != nil {
-		return nil, err
+	if info, ok := debug.ReadBuildInfo(); ok {
+		details = append(details, info.GoVersion)
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				details = append(details, "commit "+setting.Value)
+			case "vcs.time":
+				details = append(details, "committed "+setting.Value)
+			}
+		}
 	}
-
-	return buf.Bytes(), nil
+	if len(details) == 0 {
+		return "imagesToGridPdf " + version
+	}
+	return fmt.Sprintf("imagesToGridPdf %s (%s)", version, strings.Join(details, ", "))
 }
 
-func addOverlay(img image.Image) image.Image {
-	// Create a new image with the same dimensions as the resized image
-	rgba := image.NewRGBA(img.Bounds())
+// largePageCount is the number of pages above which a warning is logged.
+const largePageCount = 10000
 
-	// Draw the original image onto the new RGBA image
-	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+func printUsage() {
+	fmt.Println("Usage: go run . [options] <image_folder_path>... <number_of_pages> <output_pdf>")
+	fmt.Println("       go run . [options] --list <list_file> <number_of_pages> <output_pdf>")
+	fmt.Println("       go run . [options] --output-dir <output_folder> <image_folder_path>... <number_of_pages>")
+	fmt.Println("       go run . [options] --emit-thumbs <thumbnail_folder> <image_folder_path>...")
+	flag.PrintDefaults()
+}
 
-	// Define the size of the white square overlay
-	overlaySize := int(0.2 * float64(img.Bounds().Dx())) // 20% of the image width
+// outputFileName returns the name of the PDF generated from the image folder, archive or
+// list at source: its base name with a .pdf extension, replacing the extension of a file.
+func outputFileName(source string) string {
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs // Names "." and "images/" after the folder itself
+	}
+	base := filepath.Base(source)
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		base = strings.TrimSuffix(base, filepath.Ext(base)) // Folder names may contain dots
+	}
+	return base + ".pdf"
+}
 
-	// Define the position of the square (bottom-right corner)
-	rect := image.Rect(rgba.Bounds().Dx()-overlaySize, rgba.Bounds().Dy()-overlaySize, rgba.Bounds().Dx(), rgba.Bounds().Dy())
+// isFlagSet reports whether the named flag was passed on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	// Draw the white square
-	white := image.NewUniform(color.White)
-	draw.Draw(rgba, rect, white, image.Point{}, draw.Src)
+func atoi(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert string to int: %w", err)
+	}
+	return n, nil
+}
 
-	// Draw the black border
-	black := color.RGBA{0, 0, 0, 255}
-	for x := rect.Min.X; x < rect.Max.X; x++ {
-		rgba.Set(x, rect.Min.Y, black)
-		rgba.Set(x, rect.Max.Y-1, black)
+func clampInt(n, min, max int) int {
+	if n < min {
+		return min
 	}
-	for y := rect.Min.Y; y < rect.Max.Y; y++ {
-		rgba.Set(rect.Min.X, y, black)
-		rgba.Set(rect.Max.X-1, y, black)
+	if n > max {
+		return max
 	}
+	return n
+}
 
-	return rgba
+// parseFileSize parses a size in bytes with an optional decimal unit, e.g. "10MB", "500 kB" or
+// "2.5GB". Sizes are counted in powers of 1000, so they stay within limits given that way.
+func parseFileSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, unicode.IsLetter)
+	multiplier, ok := map[string]float64{"": 1, "B": 1, "KB": 1e3, "MB": 1e6, "GB": 1e9}[strings.ToUpper(s[len(number):])]
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if !ok || err != nil || !(size > 0) || math.IsInf(size, 0) {
+		return 0, fmt.Errorf("%q is not a positive size like 10MB", s)
+	}
+	return int64(size * multiplier), nil
 }
 
-func generatePDF(images [][]byte, numPages int, outputPDF string) {
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pageWidth, _ := pdf.GetPageSize()
-
-	// Calculate cell width and height to ensure cells are square
-	cellSize := (pageWidth - 2*marginLeft - (gridCols-1)*cellSpacing) / gridCols
-
-	for i := 0; i < numPages; i++ {
-		pdf.AddPage()
-		pdf.SetMargins(marginLeft, marginTop, marginLeft)
-
-		// Shuffle images
-		rand.Shuffle(len(images), func(i, j int) {
-			images[i], images[j] = images[j], images[i]
-		})
-
-		// Add images to the grid
-		for row := 0; row < gridRows; row++ {
-			for col := 0; col < gridCols; col++ {
-				x := marginLeft + float64(col)*(cellSize+cellSpacing)
-				y := marginTop + float64(row)*(cellSize+cellSpacing)
-				imgIndex := (i*gridRows*gridCols + row*gridCols + col) % len(images)
-				addImageToPDF(pdf, images[imgIndex], x, y, cellSize, cellSize)
-			}
-		}
-		fmt.Printf("\rGenerated page %d/%d", i+1, numPages)
+// parseAspect parses an aspect ratio given as "width:height" (e.g. "3:4") or as a single
+// number, and returns width divided by height.
+func parseAspect(s string) (float64, error) {
+	width, height, found := strings.Cut(s, ":")
+	if !found {
+		height = "1"
 	}
+	w, errW := strconv.ParseFloat(strings.TrimSpace(width), 64)
+	h, errH := strconv.ParseFloat(strings.TrimSpace(height), 64)
+	if errW != nil || errH != nil || !(w > 0) || !(h > 0) || math.IsInf(w/h, 0) {
+		return 0, fmt.Errorf("%q is not a positive ratio like 3:4", s)
+	}
+	return w / h, nil
+}
 
-	err := pdf.OutputFileAndClose(outputPDF)
+// parseHexColor parses a color in the form "#rrggbb" or "#rgb" (the leading '#' is optional).
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("%q is not a hex color like #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
 	if err != nil {
-		log.Fatalf("Failed to save PDF: %v", err)
+		return color.RGBA{}, fmt.Errorf("%q is not a hex color like #rrggbb", s)
 	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
 }
 
-func addImageToPDF(pdf *gofpdf.Fpdf, imgData []byte, x, y, w, h float64) {
-	imageName := fmt.Sprintf("img_%x", sha1.Sum(imgData)) // Generate a consistent name for the image based on its content
-	if pdf.GetImageInfo(imageName) == nil {
-		pdf.RegisterImageOptionsReader(imageName, gofpdf.ImageOptions{ImageType: "JPEG", ReadDpi: true}, bytes.NewReader(imgData))
-	}
-	pdf.ImageOptions(imageName, x, y, w, h, false, gofpdf.ImageOptions{ImageType: "JPEG", ReadDpi: true}, 0, "")
+// isTerminal reports whether f is connected to a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+	}{
+		{"#ffffff", color.RGBA{255, 255, 255, 255}},
+		{"ff8000", color.RGBA{255, 128, 0, 255}},
+		{"#12aB9c", color.RGBA{0x12, 0xab, 0x9c, 255}},
+		{"#f80", color.RGBA{255, 136, 0, 255}},
+	}
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "#", "#ff", "#ffff", "#gggggg", "#fffffff"} {
+		if got, err := parseHexColor(in); err == nil {
+			t.Errorf("parseHexColor(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseAspect(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"1:1", 1},
+		{"3:4", 0.75},
+		{"16:9", 16.0 / 9},
+		{" 3 : 2 ", 1.5},
+		{"1.5", 1.5},
+	}
+	for _, tt := range tests {
+		got, err := parseAspect(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseAspect(%q) = %g, %v, want %g", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", ":", "3:", "0:1", "3:0", "-3:4", "a:b", "3:4:5", "1e400:1"} {
+		if got, err := parseAspect(in); err == nil {
+			t.Errorf("parseAspect(%q) = %g, want an error", in, got)
+		}
+	}
+}
+
+func TestParseFileSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1024", 1024},
+		{"1B", 1},
+		{"10MB", 10_000_000},
+		{"500 kB", 500_000},
+		{"2.5GB", 2_500_000_000},
+		{" 3mb ", 3_000_000},
+	}
+	for _, tt := range tests {
+		got, err := parseFileSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseFileSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "MB", "0", "0MB", "-1MB", "10TB", "10 MiB", "ten MB", "1e400"} {
+		if got, err := parseFileSize(in); err == nil {
+			t.Errorf("parseFileSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestOutputFileName(t *testing.T) {
+	dir := t.TempDir()
+	folder := filepath.Join(dir, "summer.2024")
+	list := filepath.Join(dir, "party.txt")
+	if err := os.Mkdir(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(list, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{ source, want string }{
+		{folder, "summer.2024.pdf"}, // Folder names keep their dots
+		{folder + string(filepath.Separator), "summer.2024.pdf"},
+		{list, "party.pdf"},
+	}
+	for _, tt := range tests {
+		if got := outputFileName(tt.source); got != tt.want {
+			t.Errorf("outputFileName(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}